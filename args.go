@@ -0,0 +1,510 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// normalizeArgs rewrites command line arguments into the form
+// understood by the core flag package. Like the core parser, every
+// pass stops at the first argument that is not a flag or at a "--"
+// terminator, passing the rest through untouched for positional
+// arguments and subcommands.
+func (fs *FlagSet) normalizeArgs(args []string) ([]string, error) {
+	if fs.responseFiles {
+		var err error
+		if args, _, err = fs.expandResponseFiles(args, nil); err != nil {
+			return nil, err
+		}
+	}
+	args, err := fs.resolveNames(args)
+	if err != nil {
+		return nil, err
+	}
+	args = fs.joinRestOfLine(args)
+	args, err = fs.expandClusters(args)
+	if err != nil {
+		return nil, err
+	}
+	return fs.expandIndexed(args)
+}
+
+// EnableResponseFiles lets an argument "@file" stand for the arguments
+// read from file, which are spliced in its place. Arguments are
+// separated by whitespace or newlines, lines beginning with # are
+// comments, and files may name further response files. Files are read
+// as UTF-8, or as UTF-16 if they begin with a byte order mark, as
+// written by some Windows tools. The values of
+// flags, such as --input @data.txt, are not expanded, nor is anything
+// after a flag added by AddRestOfLineFlag.
+func (fs *FlagSet) EnableResponseFiles() {
+	fs.responseFiles = true
+}
+
+// expandResponseFiles replaces each "@file" argument with the
+// arguments read from file. Files being expanded are listed in open,
+// so that a file including itself is an error rather than a loop. It
+// reports whether the first non-flag argument, a "--" terminator or a
+// rest-of-line flag was reached, after which nothing is expanded.
+func (fs *FlagSet) expandResponseFiles(args []string, open []string) ([]string, bool, error) {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "@") || len(arg) == 1 {
+			name, _, hasValue, ok := parseFlagArg(arg)
+			if !ok {
+				return append(out, args[i:]...), true, nil
+			}
+			out = append(out, arg)
+
+			// Names are resolved later; ambiguous ones are reported then
+			dashes := arg[:len(arg)-len(strings.TrimLeft(arg, "-"))]
+			if key, err := fs.resolveName(name, dashes); err == nil && key != "" {
+				name = key
+			}
+			if fs.isRestOfLine(name) {
+				return append(out, args[i+1:]...), true, nil
+			}
+
+			// Pass the value of a flag taking one through untouched
+			if !hasValue && fs.coreFlagSet.Lookup(name) != nil && !fs.isBoolName(name) && i+1 < len(args) {
+				i++
+				out = append(out, args[i])
+			}
+			continue
+		}
+
+		path := filepath.Clean(arg[1:])
+		for _, p := range open {
+			if p == path {
+				return nil, false, fmt.Errorf("%q: response file includes itself", arg)
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("%q: %v", arg, err)
+		}
+		text, err := decodeResponseFile(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("%q: %v", arg, err)
+		}
+
+		var tokens []string
+		for _, line := range strings.Split(text, "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+			tokens = append(tokens, strings.Fields(line)...)
+		}
+
+		expanded, terminated, err := fs.expandResponseFiles(tokens, append(open, path))
+		if err != nil {
+			return nil, false, err
+		}
+		out = append(out, expanded...)
+		if terminated {
+			return append(out, args[i+1:]...), true, nil
+		}
+	}
+	return out, false, nil
+}
+
+// decodeResponseFile returns the text of a response file, which is
+// UTF-8 unless it begins with a UTF-16 byte order mark
+func decodeResponseFile(data []byte) (string, error) {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		order = binary.BigEndian
+	default:
+		data = bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf})
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("invalid UTF-8")
+		}
+		return string(data), nil
+	}
+
+	data = data[2:]
+	if len(data)%2 != 0 {
+		return "", fmt.Errorf("invalid UTF-16: odd number of bytes")
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+
+	// utf16.Decode replaces unpaired surrogates rather than failing
+	var b strings.Builder
+	for i := 0; i < len(units); i++ {
+		r := rune(units[i])
+		if utf16.IsSurrogate(r) {
+			if i+1 == len(units) {
+				return "", fmt.Errorf("invalid UTF-16: unpaired surrogate")
+			}
+			if r = utf16.DecodeRune(r, rune(units[i+1])); r == utf8.RuneError {
+				return "", fmt.Errorf("invalid UTF-16: unpaired surrogate")
+			}
+			i++
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+// EnablePrefixMatching lets long flag names be abbreviated on the
+// command line to any prefix matching a single flag, so that "--out"
+// may be given for "--output". An exact name always takes priority;
+// a prefix matching several flags is an error.
+func (fs *FlagSet) EnablePrefixMatching() {
+	fs.prefixMatch = true
+}
+
+// SetCaseInsensitive controls whether long flag names are matched
+// ignoring case, on the command line and by the getters, so that
+// "--OUTPUT" sets "output". Short names, such as -v and -V, remain
+// case sensitive. Long names differing only in case are then the same
+// name: it is an error if flags already defined have such names, and
+// flags added later may not introduce them.
+func (fs *FlagSet) SetCaseInsensitive(insensitive bool) error {
+	if insensitive {
+		seen := make(map[string]*Flag)
+		for _, f := range sortFlags(fs.flag) {
+			for _, name := range append([]string{f.key}, f.aliases...) {
+				folded := strings.ToLower(name)
+				if other, ok := seen[folded]; ok && other != f {
+					return fmt.Errorf("%q: already defined by %s flag %q ignoring case", name, other.flagType, other.key)
+				}
+				seen[folded] = f
+			}
+		}
+	}
+
+	fs.caseFold = insensitive
+	return nil
+}
+
+// canonical returns the key of the flag named by key, which may be
+// an alias or, when names are case insensitive, differ in case
+func (fs *FlagSet) canonical(key string) string {
+	if _, ok := fs.flag[key]; ok {
+		return key
+	}
+	for _, f := range sortFlags(fs.flag) {
+		for _, name := range append([]string{f.key}, f.aliases...) {
+			if name == key || fs.caseFold && strings.EqualFold(name, key) {
+				return f.key
+			}
+		}
+	}
+	return key
+}
+
+// resolveNames rewrites long flag names given in another case, when
+// names are case insensitive, and abbreviated long flag names, "--out"
+// or "--out=value", to the full name of the flag they match. Arguments
+// after a flag added by AddRestOfLineFlag are its value and are left
+// as given.
+func (fs *FlagSet) resolveNames(args []string) ([]string, error) {
+	if !fs.prefixMatch && !fs.caseFold {
+		return args, nil
+	}
+
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue, ok := parseFlagArg(arg)
+		if !ok {
+			out = append(out, args[i:]...)
+			break
+		}
+
+		dashes := arg[:len(arg)-len(strings.TrimLeft(arg, "-"))]
+		key, err := fs.resolveName(name, dashes)
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			name, arg = key, dashes+key
+			if hasValue {
+				arg += "=" + value
+			}
+		}
+		out = append(out, arg)
+
+		if fs.isRestOfLine(name) {
+			out = append(out, args[i+1:]...)
+			break
+		}
+
+		// Pass the value of a flag taking one through untouched
+		if !hasValue && fs.coreFlagSet.Lookup(name) != nil && !fs.isBoolName(name) && i+1 < len(args) {
+			i++
+			out = append(out, args[i])
+		}
+	}
+
+	return out, nil
+}
+
+// resolveName returns the key of the flag a name given with dashes
+// stands for, if it is not itself a registered name, or ""
+func (fs *FlagSet) resolveName(name, dashes string) (string, error) {
+	if fs.coreFlagSet.Lookup(name) != nil || len(name) < 2 {
+		return "", nil
+	}
+	key := fs.canonical(name)
+	if _, ok := fs.flag[key]; ok {
+		return key, nil
+	}
+	if fs.prefixMatch && dashes == "--" {
+		return fs.matchPrefix(name)
+	}
+	return "", nil
+}
+
+// isRestOfLine reports whether name is registered for a flag added by
+// AddRestOfLineFlag
+func (fs *FlagSet) isRestOfLine(name string) bool {
+	f := fs.flagByName(name)
+	return f != nil && f.restOfLine
+}
+
+// matchPrefix returns the key of the only flag whose key begins with
+// prefix, or "" if there is none
+func (fs *FlagSet) matchPrefix(prefix string) (string, error) {
+	var matches []string
+	for _, f := range sortFlags(fs.flag) {
+		if strings.HasPrefix(f.key, prefix) ||
+			fs.caseFold && strings.HasPrefix(strings.ToLower(f.key), strings.ToLower(prefix)) {
+			matches = append(matches, "--"+f.key)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		return matches[0][2:], nil
+	}
+	return "", fmt.Errorf("%q: ambiguous flag, could be %s", "--"+prefix, strings.Join(matches, ", "))
+}
+
+// joinRestOfLine rewrites a flag added by AddRestOfLineFlag and every
+// argument following it, up to a "--" terminator, into the flag and a
+// single space separated value
+func (fs *FlagSet) joinRestOfLine(args []string) []string {
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue, ok := parseFlagArg(args[i])
+		if !ok {
+			break
+		}
+		if fs.coreFlagSet.Lookup(name) == nil {
+			continue
+		}
+		if !fs.isRestOfLine(name) {
+			// Skip the value of a flag taking one
+			if !hasValue && !fs.isBoolName(name) {
+				i++
+			}
+			continue
+		}
+
+		end := i + 1
+		for end < len(args) && args[end] != "--" {
+			end++
+		}
+		words := args[i+1 : end]
+		if hasValue {
+			words = append([]string{value}, words...)
+		}
+
+		out := append([]string{}, args[:i]...)
+		out = append(out, "--"+name, strings.Join(words, " "))
+		return append(out, args[end:]...)
+	}
+
+	return args
+}
+
+// expandClusters rewrites clusters of single character short flags,
+// such as "-abc", into "-a -b -c". A cluster is only expanded when it
+// is not itself a registered name and every character is the short
+// name of a flag that takes no value. A cluster naming a flag that
+// takes a value is an error; any other cluster is left as is.
+func (fs *FlagSet) expandClusters(args []string) ([]string, error) {
+	var out []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, _, hasValue, ok := parseFlagArg(arg)
+		if !ok {
+			out = append(out, args[i:]...)
+			break
+		}
+
+		// Pass the value of a registered flag through untouched
+		if fs.coreFlagSet.Lookup(name) != nil {
+			out = append(out, arg)
+			if !hasValue && !fs.isBoolName(name) && i+1 < len(args) {
+				i++
+				out = append(out, args[i])
+			}
+			continue
+		}
+
+		if hasValue || strings.HasPrefix(arg, "--") || len(name) < 2 {
+			out = append(out, arg)
+			continue
+		}
+
+		expanded, err := fs.cluster(name)
+		if err != nil {
+			return nil, err
+		}
+		if expanded == nil {
+			out = append(out, arg)
+			continue
+		}
+		out = append(out, expanded...)
+	}
+
+	return out, nil
+}
+
+// cluster returns the short flags making up a cluster, or nil if the
+// cluster contains characters that are not registered names
+func (fs *FlagSet) cluster(name string) ([]string, error) {
+	var expanded []string
+	var valued string
+
+	for _, c := range name {
+		short := string(c)
+		if fs.coreFlagSet.Lookup(short) == nil {
+			return nil, nil
+		}
+		if !fs.isBoolName(short) && valued == "" {
+			valued = short
+		}
+		expanded = append(expanded, "-"+short)
+	}
+
+	if valued != "" {
+		return nil, fmt.Errorf("%q: flag %q takes a value and cannot be combined", "-"+name, valued)
+	}
+	return expanded, nil
+}
+
+// SetStrictIndexes controls whether missing indices in indexed flags
+// such as "--header.0 a --header.2 c" are errors. When not strict, the
+// gaps are filled with empty values.
+func (fs *FlagSet) SetStrictIndexes(strict bool) {
+	fs.strictIndex = strict
+}
+
+// maxIndex caps the index of an indexed flag, so that a typo such as
+// "--header.100000000" is an error rather than an enormous allocation
+// filling the gaps
+const maxIndex = 1 << 16
+
+// expandIndexed rewrites indexed flags, "--name.N value" or
+// "--name.N=value", into repeated "--name value" arguments ordered by
+// index. The repeated arguments replace the first indexed occurrence.
+// Indexes only apply to registered flags; a registered name containing
+// a dot is never treated as indexed.
+func (fs *FlagSet) expandIndexed(args []string) ([]string, error) {
+	indexed := make(map[string]map[int]string)
+	first := make(map[int]string)
+	var out []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue, ok := parseFlagArg(arg)
+		if !ok {
+			out = append(out, args[i:]...)
+			break
+		}
+
+		// Pass the value of a registered flag through untouched
+		if fs.coreFlagSet.Lookup(name) != nil {
+			out = append(out, arg)
+			if !hasValue && !fs.isBoolName(name) && i+1 < len(args) {
+				i++
+				out = append(out, args[i])
+			}
+			continue
+		}
+
+		dot := strings.LastIndex(name, ".")
+		if dot < 0 || fs.coreFlagSet.Lookup(name[:dot]) == nil {
+			out = append(out, arg)
+			continue
+		}
+		index, err := strconv.Atoi(name[dot+1:])
+		if err != nil || index < 0 {
+			out = append(out, arg)
+			continue
+		}
+		if index >= maxIndex {
+			return nil, fmt.Errorf("%q: index %d exceeds %d", name, index, maxIndex-1)
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%q: missing value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		base := name[:dot]
+		if _, ok := indexed[base]; !ok {
+			indexed[base] = make(map[int]string)
+			first[len(out)] = base
+			out = append(out, "")
+		}
+		indexed[base][index] = value
+	}
+
+	if len(indexed) == 0 {
+		return args, nil
+	}
+
+	// Splice each indexed flag in at its first occurrence
+	var result []string
+	for i, arg := range out {
+		base, ok := first[i]
+		if !ok {
+			result = append(result, arg)
+			continue
+		}
+
+		max := -1
+		for index := range indexed[base] {
+			if index > max {
+				max = index
+			}
+		}
+		for index := 0; index <= max; index++ {
+			value, ok := indexed[base][index]
+			if !ok && fs.strictIndex {
+				return nil, fmt.Errorf("%q: missing index %d", base, index)
+			}
+			result = append(result, "--"+base, value)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,490 @@
+package flagplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestFlagSet_expandIndexed(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("header", "H", "Request `header`", "")
+
+	args := []string{"-x", "--header.1", "b", "--header.0=a", "file", "--", "--header.2", "c"}
+	got, err := flags.expandIndexed(args)
+	if err != nil {
+		t.Fatalf("Could not expand indexed flags: %v", err)
+	}
+
+	expect := "-x --header a --header b file -- --header.2 c"
+	if strings.Join(got, " ") != expect {
+		t.Errorf("Expected %q, got %q", expect, strings.Join(got, " "))
+	}
+
+	// Gaps are filled unless strict
+	got, _ = flags.expandIndexed([]string{"--header.2", "c"})
+	expect = "--header  --header  --header c"
+	if strings.Join(got, " ") != expect {
+		t.Errorf("Expected %q, got %q", expect, strings.Join(got, " "))
+	}
+
+	flags.SetStrictIndexes(true)
+	if _, err := flags.expandIndexed([]string{"--header.2", "c"}); err == nil {
+		t.Error("Expected error for missing index")
+	}
+
+	// Huge indexes are errors rather than enormous allocations
+	_, err = flags.expandIndexed([]string{"--header.100000000", "x"})
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Expected error for huge index, got %v", err)
+	}
+
+	// Values and arguments after the flags are never indexed flags
+	flags.AddStringFlag("output", "o", "Output file", "")
+	args = []string{"--output", "--header.0", "file", "--header.1", "b"}
+	got, _ = flags.expandIndexed(args)
+	if strings.Join(got, " ") != strings.Join(args, " ") {
+		t.Errorf("Expected %q, got %q", strings.Join(args, " "), strings.Join(got, " "))
+	}
+}
+
+func TestFlagSet_expandClusters(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddBoolFlag("recursive", "r", "Recurse into directories", false)
+	flags.AddFlag("line-number", "n", "Show line numbers")
+	flags.AddStringFlag("output", "o", "Output `directory`", "")
+	flags.AddFlag("rn", "", "A registered two letter name")
+
+	if err := flags.Parse("util", "-rn", "-o", "-rn", "pattern", "--", "-rn"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	// The registered name "rn" wins over expansion, and -o takes its value
+	if v, _ := flags.Get("rn"); !v {
+		t.Error("Expected rn to be set")
+	}
+	if v, _ := flags.GetString("output"); v != "-rn" {
+		t.Errorf("Expected output %q, got %q", "-rn", v)
+	}
+
+	got, err := flags.expandClusters([]string{"-nr", "file", "-x", "-nrx", "--", "-nr"})
+	if err != nil {
+		t.Fatalf("Could not expand clusters: %v", err)
+	}
+	expect := "-n -r file -x -nrx -- -nr"
+	if strings.Join(got, " ") != expect {
+		t.Errorf("Expected %q, got %q", expect, strings.Join(got, " "))
+	}
+
+	// A value-taking flag in the cluster is an error
+	if _, err := flags.expandClusters([]string{"-rno"}); err == nil {
+		t.Error("Expected error for cluster with a value-taking flag")
+	}
+}
+
+func TestFlagSet_AddRestOfLineFlag(t *testing.T) {
+	tests := []struct {
+		args    []string
+		message string
+		rest    string
+	}{
+		{[]string{"--message", "hello", "world", "foo"}, "hello world foo", ""},
+		{[]string{"-v", "-m", "hello", "-v", "--", "file"}, "hello -v", "file"},
+		{[]string{"--message=hello", "world"}, "hello world", ""},
+		// The value of another flag is not mistaken for the message flag
+		{[]string{"-o", "-m", "file"}, "", "file"},
+		// Arguments after the flags are left alone
+		{[]string{"file", "--message", "a", "b"}, "", "file --message a b"},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddRestOfLineFlag("message", "m", "Commit message")
+		flags.AddFlag("verbose", "v", "Verbose output")
+		flags.AddStringFlag("output", "o", "Output file", "")
+		if err := flags.Parse(append([]string{"util"}, test.args...)...); err != nil {
+			t.Fatalf("%v: Could not parse: %v", test.args, err)
+		}
+
+		if got, _ := flags.GetString("message"); got != test.message {
+			t.Errorf("Expected %q, got %q", test.message, got)
+		}
+		if got := strings.Join(flags.GetArgs(), " "); got != test.rest {
+			t.Errorf("Expected %q, got %q", test.rest, got)
+		}
+	}
+}
+
+func TestFlagSet_Parse_Terminator(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect []string
+	}{
+		{[]string{"-v", "--", "-x", "y"}, []string{"-x", "y"}},
+		{[]string{"--", "-vv", "--header.0", "a"}, []string{"-vv", "--header.0", "a"}},
+		{[]string{"-v", "--", "--"}, []string{"--"}},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddFlag("verbose", "v", "Verbose output")
+		flags.AddStringFlag("header", "H", "Request `header`", "")
+		if err := flags.Parse(append([]string{"util"}, test.args...)...); err != nil {
+			t.Fatalf("%v: Could not parse: %v", test.args, err)
+		}
+
+		got := flags.GetArgs()
+		if strings.Join(got, " ") != strings.Join(test.expect, " ") {
+			t.Errorf("Expected %q, got %q", test.expect, got)
+		}
+		if h, _ := flags.GetString("header"); h != "" {
+			t.Errorf("Expected header to be unset, got %q", h)
+		}
+	}
+}
+
+func TestFlagSet_EnablePrefixMatching(t *testing.T) {
+	tests := []struct {
+		args   []string
+		output string
+		out    bool
+		err    string
+	}{
+		// Unique prefix
+		{[]string{"--outp", "a.txt"}, "a.txt", false, ""},
+		{[]string{"--outp=a.txt"}, "a.txt", false, ""},
+		// Exact match wins over the longer "output"
+		{[]string{"--out"}, "", true, ""},
+		// Ambiguous prefix
+		{[]string{"--ou", "a.txt"}, "", false, `"--ou": ambiguous flag, could be --out, --output`},
+		// Values are not mistaken for prefixes
+		{[]string{"--output", "--o"}, "--o", false, ""},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddStringFlag("output", "o", "Output file", "")
+		flags.AddFlag("out", "x", "Write to stdout")
+		flags.EnablePrefixMatching()
+
+		err := flags.Parse(append([]string{"util"}, test.args...)...)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: Expected %q, got %v", test.args, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: Could not parse: %v", test.args, err)
+		}
+		if output, _ := flags.GetString("output"); output != test.output {
+			t.Errorf("%v: Expected %q, got %q", test.args, test.output, output)
+		}
+		if out, _ := flags.Get("out"); out != test.out {
+			t.Errorf("%v: Expected %v, got %v", test.args, test.out, out)
+		}
+	}
+}
+
+func TestFlagSet_SetCaseInsensitive(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.AddFlag("verbose", "v", "Verbose output")
+	flags.AddFlag("version", "V", "Show the version")
+	flags.SetCaseInsensitive(true)
+
+	if err := flags.Parse("util", "--OUTPUT", "a.txt", "-V"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if output, _ := flags.GetString("Output"); output != "a.txt" {
+		t.Errorf("Expected %q, got %q", "a.txt", output)
+	}
+
+	// Short names stay case sensitive
+	if verbose, _ := flags.Get("verbose"); verbose {
+		t.Error("Expected -V not to set verbose")
+	}
+	if version, _ := flags.Get("version"); !version {
+		t.Error("Expected -V to set version")
+	}
+
+	// Names are case sensitive by default
+	flags = initalizeFlagSet()
+	flags.SetOutput(new(bytes.Buffer))
+	flags.AddStringFlag("output", "o", "Output file", "")
+	if err := flags.Parse("util", "--OUTPUT", "a.txt"); err == nil {
+		t.Error("Expected error for mixed case flag")
+	}
+}
+
+func TestFlagSet_SetCaseInsensitive_Collisions(t *testing.T) {
+	// Names differing only in case cannot be added
+	flags := initalizeFlagSet()
+	flags.SetCaseInsensitive(true)
+	flags.AddStringFlag("output", "o", "Output file", "")
+	if err := flags.AddStringFlag("OUTPUT", "", "Output file", ""); err == nil {
+		t.Error("Expected error for a key differing in case")
+	}
+	if err := flags.AddAlias("output", "Output"); err == nil {
+		t.Error("Expected error for an alias differing in case")
+	}
+	flags.AddFlag("verbose", "v", "Verbose output")
+	if err := flags.AddAlias("verbose", "OUT"); err != nil {
+		t.Errorf("Could not add alias: %v", err)
+	}
+	if err := flags.AddFlag("version", "V", "Show the version"); err != nil {
+		t.Errorf("Expected short names to stay case sensitive, got %v", err)
+	}
+
+	// Nor can names already defined
+	for _, names := range [][]string{{"output", "OUTPUT"}, {"out", "Out"}} {
+		flags = initalizeFlagSet()
+		flags.AddStringFlag(names[0], "", "Output file", "")
+		flags.AddStringFlag(names[1], "", "Output file", "")
+		if err := flags.SetCaseInsensitive(true); err == nil {
+			t.Errorf("%q: Expected error for names differing in case", names)
+		}
+		if err := flags.Parse("util", "--"+names[1], "x"); err != nil {
+			t.Fatalf("Could not parse: %v", err)
+		}
+		if got, _ := flags.GetString(names[0]); got != "" {
+			t.Errorf("Expected names to stay case sensitive, got %q", got)
+		}
+	}
+}
+
+func TestFlagSet_EnableResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+	common := filepath.Join(dir, "common.txt")
+	args := filepath.Join(dir, "args.txt")
+	os.WriteFile(common, []byte("# shared settings\n--retries 5\n"), 0644)
+	os.WriteFile(args, []byte("--output out.txt\n@"+common+"\n--verbose input.txt\n"), 0644)
+
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.AddIntFlag("retries", "r", "Number of retries", 3)
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.AddStringFlag("name", "n", "Name", "")
+	flags.EnableResponseFiles()
+
+	if err := flags.Parse("util", "--name", "@scott", "@"+args, "extra", "@"+args); err != nil {
+		t.Fatalf("Could not parse response file: %v", err)
+	}
+	if got, _ := flags.GetString("output"); got != "out.txt" {
+		t.Errorf("Expected %q, got %q", "out.txt", got)
+	}
+	if got, _ := flags.GetInt("retries"); got != 5 {
+		t.Errorf("Expected %d, got %d", 5, got)
+	}
+	if got, _ := flags.GetString("name"); got != "@scott" {
+		t.Errorf("Expected flag value to be left alone, got %q", got)
+	}
+	expect := "input.txt extra @" + args
+	if got := strings.Join(flags.GetArgs(), " "); got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	// A file including itself and a missing file are errors
+	loop := filepath.Join(dir, "loop.txt")
+	os.WriteFile(loop, []byte("@"+loop), 0644)
+	missing := filepath.Join(dir, "missing.txt")
+	for path, expect := range map[string]string{
+		loop:    "response file includes itself",
+		missing: missing,
+	} {
+		flags := initalizeFlagSet()
+		flags.EnableResponseFiles()
+		if err := flags.Parse("util", "@"+path); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("Expected %q, got %v", expect, err)
+		}
+	}
+}
+
+func TestFlagSet_EnableResponseFiles_UTF16(t *testing.T) {
+	text := "# settings\r\n--output café.txt\r\n--name 🚀\r\ninput.txt\r\n"
+	encode := func(order binary.ByteOrder, bom []byte) []byte {
+		data := append([]byte(nil), bom...)
+		for _, u := range utf16.Encode([]rune(text)) {
+			unit := make([]byte, 2)
+			order.PutUint16(unit, u)
+			data = append(data, unit...)
+		}
+		return data
+	}
+
+	dir := t.TempDir()
+	parse := func(data []byte) (string, error) {
+		file := filepath.Join(dir, "args.rsp")
+		os.WriteFile(file, data, 0644)
+		flags := initalizeFlagSet()
+		flags.AddStringFlag("output", "o", "Output file", "")
+		flags.AddStringFlag("name", "n", "Name", "")
+		flags.EnableResponseFiles()
+		if err := flags.Parse("util", "@"+file); err != nil {
+			return "", err
+		}
+		output, _ := flags.GetString("output")
+		name, _ := flags.GetString("name")
+		return strings.Join(append([]string{output, name}, flags.GetArgs()...), " "), nil
+	}
+
+	expect, err := parse([]byte(text))
+	if err != nil {
+		t.Fatalf("Could not parse UTF-8 response file: %v", err)
+	}
+	for desc, data := range map[string][]byte{
+		"UTF-8 with BOM": append([]byte{0xef, 0xbb, 0xbf}, text...),
+		"UTF-16LE":       encode(binary.LittleEndian, []byte{0xff, 0xfe}),
+		"UTF-16BE":       encode(binary.BigEndian, []byte{0xfe, 0xff}),
+	} {
+		got, err := parse(data)
+		if err != nil {
+			t.Errorf("%s: Could not parse: %v", desc, err)
+		} else if got != expect {
+			t.Errorf("%s: Expected %q, got %q", desc, expect, got)
+		}
+	}
+
+	// Malformed encodings are errors
+	for desc, data := range map[string][]byte{
+		"odd UTF-16":       {0xff, 0xfe, 'a', 0, 'b'},
+		"lone surrogate":   {0xff, 0xfe, 0x3d, 0xd8, 'a', 0},
+		"ending surrogate": {0xfe, 0xff, 0, 'a', 0xd8, 0x3d},
+		"invalid UTF-8":    {'-', '-', 0xc3, 0x28},
+	} {
+		if _, err := parse(data); err == nil || !strings.Contains(err.Error(), "invalid UTF-") {
+			t.Errorf("%s: Expected encoding error, got %v", desc, err)
+		}
+	}
+}
+
+func TestFlagSet_EnableResponseFiles_RestOfLine(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "r.txt")
+	os.WriteFile(file, []byte("--output y\n"), 0644)
+
+	for _, name := range []string{"--message", "-m", "--mess"} {
+		flags := initalizeFlagSet()
+		flags.AddStringFlag("output", "o", "Output file", "")
+		flags.AddRestOfLineFlag("message", "m", "Commit message")
+		flags.EnablePrefixMatching()
+		flags.EnableResponseFiles()
+		if err := flags.Parse("util", name, "mail", "@"+file); err != nil {
+			t.Fatalf("%s: Could not parse: %v", name, err)
+		}
+		expect := "mail @" + file
+		if got, _ := flags.GetString("message"); got != expect {
+			t.Errorf("%s: Expected %q, got %q", name, expect, got)
+		}
+		if got, _ := flags.GetString("output"); got != "" {
+			t.Errorf("%s: Expected output to be unset, got %q", name, got)
+		}
+	}
+}
+
+func TestFlagSet_resolveNames_StopsAtArguments(t *testing.T) {
+	// Names after the subcommand belong to the subcommand
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.EnablePrefixMatching()
+	deploy := NewFlagSet("deploy")
+	deploy.AddStringFlag("out", "", "Deployment target", "")
+	flags.AddSubcommand("deploy", deploy)
+
+	if err := flags.ParseWithSubcommands("tool", "deploy", "--out", "x"); err != nil {
+		t.Fatalf("Could not parse subcommand: %v", err)
+	}
+	if out, _ := deploy.GetString("out"); out != "x" {
+		t.Errorf("Expected %q, got %q", "x", out)
+	}
+
+	// Positional arguments are left as given
+	flags = initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.EnablePrefixMatching()
+	flags.SetCaseInsensitive(true)
+	if err := flags.Parse("util", "--OUT", "a", "file", "--OUTPUT", "x", "--outp"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if output, _ := flags.GetString("output"); output != "a" {
+		t.Errorf("Expected %q, got %q", "a", output)
+	}
+	expect := "file --OUTPUT x --outp"
+	if got := strings.Join(flags.GetArgs(), " "); got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+func TestFlagSet_resolveNames_StopsAtRestOfLine(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"--message", "hello", "--out", "x"}, "hello --out x"},
+		{[]string{"--mess", "hello", "--OUT", "x"}, "hello --OUT x"},
+		{[]string{"--MESSAGE=hello", "--out"}, "hello --out"},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddStringFlag("output", "o", "Output file", "")
+		flags.AddRestOfLineFlag("message", "m", "Commit message")
+		flags.EnablePrefixMatching()
+		flags.SetCaseInsensitive(true)
+		if err := flags.Parse(append([]string{"util"}, test.args...)...); err != nil {
+			t.Fatalf("%q: Could not parse: %v", test.args, err)
+		}
+		if message, _ := flags.GetString("message"); message != test.expect {
+			t.Errorf("Expected %q, got %q", test.expect, message)
+		}
+		if output, _ := flags.GetString("output"); output != "" {
+			t.Errorf("Expected output to be unset, got %q", output)
+		}
+	}
+}
+
+func TestFlagSet_expandClusters_StopsAtArguments(t *testing.T) {
+	// Clusters after the first argument are positional
+	flags := initalizeFlagSet()
+	flags.AddFlag("verbose", "v", "Verbose output")
+	if err := flags.Parse("util", "-vv", "file", "-vv"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	expect := "file -vv"
+	if got := strings.Join(flags.GetArgs(), " "); got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	// Clusters after the subcommand belong to the subcommand
+	flags = initalizeFlagSet()
+	flags.AddFlag("all", "a", "All entries")
+	flags.AddFlag("brief", "b", "Brief output")
+	deploy := NewFlagSet("deploy")
+	deploy.AddFlag("ab", "", "Deploy to A and B")
+	flags.AddSubcommand("deploy", deploy)
+	if err := flags.ParseWithSubcommands("tool", "deploy", "-ab"); err != nil {
+		t.Fatalf("Could not parse subcommand: %v", err)
+	}
+	if ab, _ := deploy.Get("ab"); ab != true {
+		t.Error("Expected -ab to set the subcommand's flag")
+	}
+	if all, _ := flags.Get("all"); all != false {
+		t.Error("Expected -ab not to set the parent's -a")
+	}
+}
+
+func TestFlagSet_AddRestOfLineFlag_Subcommand(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddRestOfLineFlag("message", "m", "Commit message")
+	commit := NewFlagSet("commit")
+	commit.AddRestOfLineFlag("message", "m", "Commit message")
+	flags.AddSubcommand("commit", commit)
+
+	if err := flags.ParseWithSubcommands("tool", "commit", "-m", "fix", "typo"); err != nil {
+		t.Fatalf("Could not parse subcommand: %v", err)
+	}
+	if got, _ := commit.GetString("message"); got != "fix typo" {
+		t.Errorf("Expected %q, got %q", "fix typo", got)
+	}
+	if got, _ := flags.GetString("message"); got != "" {
+		t.Errorf("Expected parent message to be unset, got %q", got)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// nonIdent matches the characters of a program name that cannot be
+// used in a shell function name
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// BashCompletion returns a bash completion script for progName that
+// completes the long and short names of the visible flags and the
+// subcommands, including the flags of a subcommand once it is given.
+// Load it with:
+//
+//	eval "$(mytool --completion bash)"
+func (fs *FlagSet) BashCompletion(progName string) string {
+	fn := "_" + nonIdent.ReplaceAllString(progName, "_") + "_completion"
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n", progName)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(&b, "    local words=%q\n",
+		strings.Join(append(fs.flagWords(), fs.subcommandNames()...), " "))
+	if len(fs.subcommands) > 0 {
+		b.WriteString("    local i\n")
+		b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+		b.WriteString("        case \"${COMP_WORDS[i]}\" in\n")
+		for _, name := range fs.subcommandNames() {
+			fmt.Fprintf(&b, "        %s) words=%q ;;\n",
+				name, strings.Join(fs.subcommands[name].flagWords(), " "))
+		}
+		b.WriteString("        esac\n")
+		b.WriteString("    done\n")
+	}
+	b.WriteString("    COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", fn, progName)
+
+	return b.String()
+}
+
+// ZshCompletion returns a zsh completion script for progName, listing
+// each visible flag with its usage as the description, followed by the
+// subcommands. Load it with:
+//
+//	source <(mytool --completion zsh)
+func (fs *FlagSet) ZshCompletion(progName string) string {
+	fn := "_" + nonIdent.ReplaceAllString(progName, "_")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", progName)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    _arguments -s")
+	for _, spec := range fs.zshSpecs() {
+		fmt.Fprintf(&b, " \\\n        %s", spec)
+	}
+	if names := fs.subcommandNames(); len(names) > 0 {
+		fmt.Fprintf(&b, " \\\n        '1:command:(%s)'", strings.Join(names, " "))
+	}
+	b.WriteString("\n}\n\n")
+	fmt.Fprintf(&b, "compdef %s %s\n", fn, progName)
+
+	return b.String()
+}
+
+// zshSpecs returns the _arguments specification of each name of the
+// visible flags. Names of the same flag exclude each other, and flags
+// that take a value are followed by the value's name.
+func (fs *FlagSet) zshSpecs() []string {
+	escape := strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`, `'`, `'\''`)
+
+	var specs []string
+	for _, f := range fs.visibleFlags() {
+		names := []string{"--" + f.key}
+		for _, alias := range f.aliases {
+			names = append(names, "--"+alias)
+		}
+		if f.shortName != "" {
+			names = append(names, "-"+f.shortName)
+		}
+
+		name, usage := unquoteUsage(f)
+		var exclude, value string
+		if len(names) > 1 {
+			exclude = "(" + strings.Join(names, " ") + ")"
+		}
+		if !fs.isBoolName(f.key) {
+			value = ":" + escape.Replace(name) + ":"
+		}
+
+		for _, n := range names {
+			suffix := ""
+			if value != "" {
+				suffix = "="
+				if !strings.HasPrefix(n, "--") {
+					suffix = "+"
+				}
+			}
+			specs = append(specs, fmt.Sprintf("'%s%s%s[%s]%s'",
+				exclude, n, suffix, escape.Replace(usage), value))
+		}
+	}
+	return specs
+}
+
+// flagWords returns the names of the visible flags as given on the
+// command line, long names first
+func (fs *FlagSet) flagWords() []string {
+	var words []string
+	for _, f := range fs.visibleFlags() {
+		words = append(words, "--"+f.key)
+		for _, alias := range f.aliases {
+			words = append(words, "--"+alias)
+		}
+		if f.shortName != "" {
+			words = append(words, "-"+f.shortName)
+		}
+	}
+	return words
+}
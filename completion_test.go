@@ -0,0 +1,59 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlagSet_BashCompletion(t *testing.T) {
+	flags := NewFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.AddBoolFlag("debug", "", "Debug output", false)
+	flags.MarkHidden("debug")
+
+	build := NewFlagSet()
+	build.AddBoolFlag("release", "r", "Release build", false)
+	flags.AddSubcommand("build", build)
+
+	script := flags.BashCompletion("my-tool")
+	for _, expect := range []string{
+		`local words="--output -o --verbose -v build"`,
+		`build) words="--release -r" ;;`,
+		"complete -F _my_tool_completion my-tool\n",
+	} {
+		if !strings.Contains(script, expect) {
+			t.Errorf("Expected %q in script, got %q", expect, script)
+		}
+	}
+	if strings.Contains(script, "--debug") {
+		t.Errorf("Expected hidden flag to be left out, got %q", script)
+	}
+}
+
+func TestFlagSet_ZshCompletion(t *testing.T) {
+	flags := NewFlagSet()
+	flags.AddStringFlag("output", "o", "Output `file` [default stdout]", "")
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.AddIntFlag("retries", "", "Number of retries", 3)
+	flags.AddSubcommand("build", NewFlagSet())
+
+	script := flags.ZshCompletion("my-tool")
+	for _, expect := range []string{
+		"#compdef my-tool\n",
+		`'(--output -o)--output=[Output file \[default stdout\]]:file:'`,
+		`'(--output -o)-o+[Output file \[default stdout\]]:file:'`,
+		`'(--verbose -v)--verbose[Verbose output]'`,
+		`'--retries=[Number of retries]:int:'`,
+		`'1:command:(build)'`,
+		"compdef _my_tool my-tool\n",
+	} {
+		if !strings.Contains(script, expect) {
+			t.Errorf("Expected %q in script, got %q", expect, script)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LoadDefaults replaces the defaults of flags with values read from a
+// JSON object mapping flag keys to values, such as a configuration
+// file. Keys that are not flags are ignored. Loaded defaults are used
+// unless the flag is given through the environment or command line,
+// so it should be called before Parse.
+func (fs *FlagSet) LoadDefaults(r io.Reader) error {
+	var values map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		return fmt.Errorf("could not read defaults: %v", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Check every value before changing any flag
+	defaults := make(map[*Flag]interface{}, len(values))
+	for _, f := range sortFlags(fs.flag) {
+		raw, ok := values[f.key]
+		if !ok {
+			continue
+		}
+		v, err := f.decodeLoaded(raw)
+		if err != nil {
+			return fmt.Errorf("%q: invalid default: %v", f.key, err)
+		}
+		defaults[f] = v
+	}
+
+	applyDefaults(defaults)
+	return nil
+}
+
+// LoadINI is like LoadDefaults but reads "key = value" lines from the
+// named section of an INI file, or from the lines before any section
+// if section is "". Values are given as on the command line, and a key
+// given more than once is applied once for each value, as a repeated
+// flag is. An empty value leaves a string, slice, IP, URL or regular
+// expression flag unset. Lines beginning with ; or # are comments, and
+// keys that are not flags are ignored.
+func (fs *FlagSet) LoadINI(r io.Reader, section string) error {
+	values := make(map[string][]string)
+	current := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == ';' || line[0] == '#':
+			continue
+		case line[0] == '[':
+			if line[len(line)-1] != ']' {
+				return fmt.Errorf("could not read defaults: line %d: unterminated section", n)
+			}
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("could not read defaults: line %d: expected key = value", n)
+		}
+		if current == section {
+			value = strings.TrimSpace(value)
+			if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			key = strings.TrimSpace(key)
+			values[key] = append(values[key], value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read defaults: %v", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Check every value before changing any flag
+	defaults := make(map[*Flag]interface{}, len(values))
+	for _, f := range sortFlags(fs.flag) {
+		list, ok := values[f.key]
+		if !ok {
+			continue
+		}
+		v, err := fs.parseLoaded(f, list)
+		if err != nil {
+			return fmt.Errorf("%q: invalid default: %v", f.key, err)
+		}
+		defaults[f] = v
+	}
+
+	applyDefaults(defaults)
+	return nil
+}
+
+// iniQuote quotes an INI value that LoadINI would otherwise trim or
+// unquote
+func iniQuote(value string) string {
+	if value != strings.TrimSpace(value) ||
+		len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+// applyDefaults makes loaded values the defaults of their flags
+func applyDefaults(defaults map[*Flag]interface{}) {
+	for f, v := range defaults {
+		if !f.loaded {
+			f.baseDefault = f.defaultValue
+			f.loaded = true
+		}
+		f.defaultValue = v
+		f.set(v)
+	}
+}
+
+// parseLoaded parses a default loaded for the flag from one or more
+// values as they would be given on the command line, leaving the
+// flag's value unchanged, and checks it against the flag's own
+// constraints
+func (fs *FlagSet) parseLoaded(f *Flag, list []string) (interface{}, error) {
+	if err := f.loadable(); err != nil {
+		return nil, err
+	}
+	if len(list) == 1 && list[0] == "" && emptyIsUnset(f.flagType) {
+		return zeroValue(f.flagType), nil
+	}
+
+	value := fs.coreFlagSet.Lookup(f.key).Value
+	r, _ := value.(resetter)
+	saved := f.get()
+	defer func() {
+		f.set(saved)
+		if r != nil {
+			r.reset()
+		}
+	}()
+	if r != nil {
+		r.reset()
+	}
+
+	for _, s := range list {
+		if err := value.Set(s); err != nil {
+			return nil, err
+		}
+	}
+	v := f.get()
+	return v, f.checkLoaded(v)
+}
+
+// decodeLoaded decodes a default loaded for the flag, checking it
+// against the flag's own constraints
+func (f *Flag) decodeLoaded(raw json.RawMessage) (interface{}, error) {
+	if err := f.loadable(); err != nil {
+		return nil, err
+	}
+
+	v, err := decodeDefault(f.flagType, raw)
+	if err != nil {
+		return nil, err
+	}
+	return v, f.checkLoaded(v)
+}
+
+// emptyIsUnset reports whether the zero value of a flag type is
+// written as an empty string, so that an empty loaded value stands for
+// it rather than being parsed
+func emptyIsUnset(t FlagType) bool {
+	switch t {
+	case STRING, SEMVER, STRINGSLICE, INTSLICE, IP, URL, REGEXP:
+		return true
+	}
+	return false
+}
+
+// loadable reports whether the flag's type can have a loaded default
+func (f *Flag) loadable() error {
+	switch f.flagType {
+	case BASE, TYPEDMAP, SORTSPEC, COUNT:
+		return fmt.Errorf("%s flags have no default", f.flagType)
+	}
+	return nil
+}
+
+// checkLoaded checks a loaded default against the flag's own
+// constraints
+func (f *Flag) checkLoaded(v interface{}) error {
+	switch {
+	case f.flagType == SEMVER && v.(string) != "":
+		if _, _, _, err := parseSemVer(v.(string)); err != nil {
+			return err
+		}
+	case f.flagType == URL:
+		return checkURL(v.(*url.URL), f.schemes)
+	case f.bounds != nil:
+		if n := v.(int64); n < f.bounds[0] || n > f.bounds[1] {
+			return fmt.Errorf("value %d out of range [%d,%d]", n, f.bounds[0], f.bounds[1])
+		}
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding the current value of
+// every flag as a JSON object keyed by long name. Durations, URLs,
+// regular expressions and sort specifications are encoded as strings
+// such as "1m30s" and "name,-date", as they are given on the command
+// line. Before Parse the object is empty.
+func (fs *FlagSet) MarshalJSON() ([]byte, error) {
+	values := fs.Values()
+	for k, v := range values {
+		values[k] = fs.flag[k].jsonValue(v)
+	}
+	return json.Marshal(values)
+}
+
+// jsonValue returns a value of the flag in the form it is encoded as
+// JSON. Unset URLs and regular expressions are null.
+func (f *Flag) jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case *url.URL:
+		if v == nil {
+			return nil
+		}
+	case *regexp.Regexp:
+		if v == nil {
+			return nil
+		}
+	case []SortField:
+	default:
+		return v
+	}
+	return f.text(v)
+}
+
+// WriteConfigTemplate writes a configuration file setting every flag
+// to its default, as a starting point for LoadINI when format is "ini"
+// or LoadDefaults when it is "json"; either loads back unchanged. INI
+// files give the usage of each flag as a comment and repeat the key for
+// each element of a string slice. Flags whose type cannot have a loaded
+// default, such as COUNT, are left out.
+func (fs *FlagSet) WriteConfigTemplate(w io.Writer, format string) error {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	var flags []*Flag
+	for _, f := range sortFlags(fs.flag) {
+		if f.loadable() == nil {
+			flags = append(flags, f)
+		}
+	}
+
+	var b strings.Builder
+	switch format {
+	case "ini":
+		for i, f := range flags {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			_, usage := unquoteUsage(f)
+			fmt.Fprintf(&b, "; %s\n", strings.ReplaceAll(usage, "\n", "\n; "))
+			values := []string{f.text(f.defaultValue)}
+			if f.flagType == STRINGSLICE && len(f.defaultValue.([]string)) > 0 {
+				values = f.defaultValue.([]string)
+			}
+			for _, value := range values {
+				fmt.Fprintf(&b, "%s = %s\n", f.key, iniQuote(value))
+			}
+		}
+	case "json":
+		values := make(map[string]interface{}, len(flags))
+		for _, f := range flags {
+			values[f.key] = f.jsonValue(f.defaultValue)
+		}
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteString("\n")
+	default:
+		return fmt.Errorf("unknown config format %q, must be one of [ini|json]", format)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
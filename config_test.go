@@ -0,0 +1,309 @@
+package flagplus
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlagSet_LoadDefaults(t *testing.T) {
+	config := `{"line": 20, "output": "/tmp/out", "other": true}`
+	setup := func() *FlagSet {
+		flags := initalizeFlagSet()
+		flags.AddIntFlag("line", "l", "Line Number", 1)
+		flags.AddStringFlag("output", "o", "Output file", "")
+		if err := flags.LoadDefaults(strings.NewReader(config)); err != nil {
+			t.Fatalf("Could not load defaults: %v", err)
+		}
+		return flags
+	}
+
+	// Loaded defaults apply when no flag is given
+	flags := setup()
+	if err := flags.Parse("util"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if line, _ := flags.GetInt("line"); line != 20 {
+		t.Errorf("Expected %d, got %d", 20, line)
+	}
+	if output, _ := flags.GetString("output"); output != "/tmp/out" {
+		t.Errorf("Expected %q, got %q", "/tmp/out", output)
+	}
+	if src, _ := flags.Source("line"); src != SourceConfig {
+		t.Errorf("Expected %q, got %q", SourceConfig, src)
+	}
+
+	// The command line overrides loaded defaults
+	flags = setup()
+	if err := flags.Parse("util", "-l", "5"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if line, _ := flags.GetInt("line"); line != 5 {
+		t.Errorf("Expected %d, got %d", 5, line)
+	}
+	chain, _ := flags.PrecedenceChain("line")
+	expect := []SourceValue{{SourceDefault, "1"}, {SourceConfig, "20"}, {SourceCLI, "5"}}
+	if len(chain) != len(expect) || chain[0] != expect[0] || chain[1] != expect[1] || chain[2] != expect[2] {
+		t.Errorf("Expected %v, got %v", expect, chain)
+	}
+}
+
+func TestFlagSet_LoadDefaults_TypeMismatch(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddStringFlag("output", "o", "Output file", "")
+
+	err := flags.LoadDefaults(strings.NewReader(`{"line": "20", "output": "/tmp/out"}`))
+	if err == nil || !strings.HasPrefix(err.Error(), `"line": `) {
+		t.Fatalf("Expected error naming the key, got %v", err)
+	}
+
+	// Nothing is loaded when any value is invalid
+	flags.Parse("util")
+	if output, _ := flags.GetString("output"); output != "" {
+		t.Errorf("Expected %q, got %q", "", output)
+	}
+}
+
+func TestFlagSet_MarshalJSON(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.AddDurationFlag("timeout", "t", "Timeout", 0)
+	flags.Parse("util", "-l", "42", "-t", "90s")
+
+	data, err := json.Marshal(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"line":42,"output":"","timeout":"1m30s"}`
+	if string(data) != expect {
+		t.Errorf("Expected %s, got %s", expect, data)
+	}
+
+	// The encoded values load back as defaults
+	loaded := initalizeFlagSet()
+	loaded.AddIntFlag("line", "l", "Line Number", 1)
+	loaded.AddStringFlag("output", "o", "Output file", "")
+	loaded.AddDurationFlag("timeout", "t", "Timeout", 0)
+	if err := loaded.LoadDefaults(strings.NewReader(string(data))); err != nil {
+		t.Fatalf("Could not load marshaled values: %v", err)
+	}
+	loaded.Parse("util")
+	if line, _ := loaded.GetInt("line"); line != 42 {
+		t.Errorf("Expected %d, got %d", 42, line)
+	}
+}
+
+func TestFlagSet_LoadINI(t *testing.T) {
+	config := `
+; deployment settings
+line = 99
+
+[server]
+line = 20
+output = "/tmp/out"
+other = true
+
+[client]
+line = 30
+`
+	setup := func() *FlagSet {
+		flags := initalizeFlagSet()
+		flags.AddIntFlag("line", "l", "Line Number", 1)
+		flags.AddStringFlag("output", "o", "Output file", "")
+		if err := flags.LoadINI(strings.NewReader(config), "server"); err != nil {
+			t.Fatalf("Could not load defaults: %v", err)
+		}
+		return flags
+	}
+
+	// Loaded defaults apply when no flag is given
+	flags := setup()
+	if err := flags.Parse("util"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if line, _ := flags.GetInt("line"); line != 20 {
+		t.Errorf("Expected %d, got %d", 20, line)
+	}
+	if output, _ := flags.GetString("output"); output != "/tmp/out" {
+		t.Errorf("Expected %q, got %q", "/tmp/out", output)
+	}
+
+	// The command line overrides loaded defaults
+	flags = setup()
+	if err := flags.Parse("util", "-l", "5"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if line, _ := flags.GetInt("line"); line != 5 {
+		t.Errorf("Expected %d, got %d", 5, line)
+	}
+	if src, _ := flags.Source("output"); src != SourceConfig {
+		t.Errorf("Expected %q, got %q", SourceConfig, src)
+	}
+
+	// Slices loaded from a file are still replaced on the command line
+	flags = initalizeFlagSet()
+	flags.AddStringSliceFlag("tag", "t", "Tags", nil)
+	flags.LoadINI(strings.NewReader("tag = prod\n"), "")
+	if err := flags.Parse("util", "-t", "dev"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if tags, _ := flags.GetStringSlice("tag"); len(tags) != 1 || tags[0] != "dev" {
+		t.Errorf("Expected %v, got %v", []string{"dev"}, tags)
+	}
+
+	// Values are parsed per the flag's type
+	flags = initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	err := flags.LoadINI(strings.NewReader("line = twenty\n"), "")
+	if err == nil || !strings.HasPrefix(err.Error(), `"line": invalid default`) {
+		t.Errorf("Expected error naming the key, got %v", err)
+	}
+}
+
+func TestFlagSet_WriteConfigTemplate(t *testing.T) {
+	setup := func() *FlagSet {
+		flags := initalizeFlagSet()
+		flags.AddIntFlag("line", "l", "Line Number", 1)
+		flags.AddStringFlag("output", "o", "Output `file`", "/tmp/out")
+		flags.AddDurationFlag("timeout", "t", "Request timeout", 90*time.Second)
+		flags.AddURLFlag("endpoint", "e", "API endpoint", nil)
+		flags.AddCountFlag("verbose", "v", "Verbosity")
+		return flags
+	}
+
+	var b strings.Builder
+	if err := setup().WriteConfigTemplate(&b, "ini"); err != nil {
+		t.Fatalf("Could not write template: %v", err)
+	}
+	expect := `; API endpoint
+endpoint = 
+
+; Line Number
+line = 1
+
+; Output file
+output = /tmp/out
+
+; Request timeout
+timeout = 1m30s
+`
+	if b.String() != expect {
+		t.Errorf("Expected %q, got %q", expect, b.String())
+	}
+
+	// Templates load back unchanged
+	flags := setup()
+	if err := flags.LoadINI(strings.NewReader(b.String()), ""); err != nil {
+		t.Errorf("Could not load INI template: %v", err)
+	}
+	b.Reset()
+	flags.WriteConfigTemplate(&b, "json")
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(b.String()), &values); err != nil {
+		t.Fatalf("Could not decode JSON template: %v", err)
+	}
+	if len(values) != 4 || values["endpoint"] != nil || values["timeout"] != "1m30s" {
+		t.Errorf("Expected defaults in JSON template, got %v", values)
+	}
+	if err := setup().LoadDefaults(strings.NewReader(b.String())); err != nil {
+		t.Errorf("Could not load JSON template: %v", err)
+	}
+
+	if err := flags.WriteConfigTemplate(&b, "yaml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestFlagSet_WriteConfigTemplate_RoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.yml")
+	os.WriteFile(file, []byte("debug: true\n"), 0644)
+
+	// Each loadable type, with either set or zero defaults
+	setup := func(set bool) *FlagSet {
+		flags := initalizeFlagSet()
+		if set {
+			endpoint, _ := url.Parse("https://api.example.com/v1")
+			flags.AddBoolFlag("bool", "", "BOOL", true)
+			flags.AddIntFlag("int", "", "INT", -7)
+			flags.AddFloatFlag("float", "", "FLOAT", 2.5)
+			flags.AddStringFlag("string", "", "STRING", " padded ")
+			flags.AddStringFlag("quoted", "", "STRING", `"quoted"`)
+			flags.AddSemVerFlag("semver", "", "SEMVER", "1.2.3")
+			flags.AddBytesFlag("bytes", "", "BYTES", 1<<20)
+			flags.AddDurationFlag("duration", "", "DURATION", 90*time.Second)
+			flags.AddStringSliceFlag("strings", "", "STRINGSLICE", []string{"a,b", "c"})
+			flags.AddUintFlag("uint", "", "UINT", 42)
+			flags.AddIntSliceFlag("ints", "", "INTSLICE", []int64{1, -2})
+			flags.AddIPFlag("ip", "", "IP", net.ParseIP("10.0.0.1"))
+			flags.AddTimeFlag("time", "", "TIME", "2006-01-02", time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC))
+			flags.AddURLFlag("url", "", "URL", endpoint)
+			flags.AddRegexpFlag("regexp", "", "REGEXP", regexp.MustCompile(`^ERROR\s`))
+			flags.AddFilePathFlag("path", "", "Path", file, true)
+			flags.AddPercentFlag("percent", "", "Percent", 25)
+			flags.AddEnumFlag("enum", "", "Enum", []string{"a", "b"}, "b")
+		} else {
+			flags.AddBoolFlag("bool", "", "BOOL", false)
+			flags.AddIntFlag("int", "", "INT", 0)
+			flags.AddFloatFlag("float", "", "FLOAT", 0)
+			flags.AddStringFlag("string", "", "STRING", "")
+			flags.AddStringFlag("quoted", "", "STRING", "")
+			flags.AddSemVerFlag("semver", "", "SEMVER", "")
+			flags.AddBytesFlag("bytes", "", "BYTES", 0)
+			flags.AddDurationFlag("duration", "", "DURATION", 0)
+			flags.AddStringSliceFlag("strings", "", "STRINGSLICE", nil)
+			flags.AddUintFlag("uint", "", "UINT", 0)
+			flags.AddIntSliceFlag("ints", "", "INTSLICE", nil)
+			flags.AddIPFlag("ip", "", "IP", nil)
+			flags.AddTimeFlag("time", "", "TIME", "2006-01-02", time.Time{})
+			flags.AddURLFlag("url", "", "URL", nil)
+			flags.AddRegexpFlag("regexp", "", "REGEXP", nil)
+			flags.AddFilePathFlag("path", "", "Path", "", true)
+			flags.AddPercentFlag("percent", "", "Percent", 0)
+			flags.AddEnumFlag("enum", "", "Enum", []string{"a", "b"}, "a")
+		}
+		flags.AddCountFlag("count", "", "COUNT")
+		return flags
+	}
+
+	for _, format := range []string{"ini", "json"} {
+		for _, set := range []bool{true, false} {
+			var b strings.Builder
+			src := setup(set)
+			if err := src.WriteConfigTemplate(&b, format); err != nil {
+				t.Fatalf("Could not write %s template: %v", format, err)
+			}
+
+			// Load into flags with the opposite defaults
+			dst := setup(!set)
+			var err error
+			if format == "ini" {
+				err = dst.LoadINI(strings.NewReader(b.String()), "")
+			} else {
+				err = dst.LoadDefaults(strings.NewReader(b.String()))
+			}
+			if err != nil {
+				t.Fatalf("Could not load %s template %q: %v", format, b.String(), err)
+			}
+
+			src.Parse("util")
+			dst.Parse("util")
+			src.VisitAll(func(f *Flag) {
+				want, _ := src.GetAsString(f.Key())
+				if got, _ := dst.GetAsString(f.Key()); got != want {
+					t.Errorf("%s %q: Expected %q, got %q", format, f.Key(), want, got)
+				}
+			})
+			if strings, _ := dst.GetStringSlice("strings"); set && len(strings) != 2 {
+				t.Errorf("%s: Expected 2 strings, got %q", format, strings)
+			}
+		}
+	}
+}
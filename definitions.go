@@ -0,0 +1,430 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Kinds of flag whose values are parsed by something other than the
+// plain parser for their type
+const (
+	kindGlob     = "glob"
+	kindLines    = "lines"
+	kindRanges   = "ranges"
+	kindPath     = "path"
+	kindPercent  = "percent"
+	kindSort     = "sort"
+	kindSigned   = "signed"
+	kindIntFile  = "intfile"
+	kindQuantity = "quantity"
+	kindRest     = "rest"
+	kindEnum     = "enum"
+)
+
+// flagSetDefinition is the serialized form of a FlagSet definition
+type flagSetDefinition struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Semantics   string           `json:"semantics,omitempty"`
+	EnvPrefix   string           `json:"envPrefix,omitempty"`
+	Help        bool             `json:"help,omitempty"`
+	Version     string           `json:"version,omitempty"`
+	Flags       []flagDefinition `json:"flags"`
+}
+
+// flagDefinition is the serialized form of a flag definition
+type flagDefinition struct {
+	Key       string             `json:"key"`
+	ShortName string             `json:"shortName,omitempty"`
+	Aliases   []string           `json:"aliases,omitempty"`
+	Type      string             `json:"type"`
+	Kind      string             `json:"kind,omitempty"`
+	Default   json.RawMessage    `json:"default,omitempty"`
+	Usage     string             `json:"usage"`
+	Group     string             `json:"group,omitempty"`
+	Hidden    bool               `json:"hidden,omitempty"`
+	Required  bool               `json:"required,omitempty"`
+	Requires  []string           `json:"requires,omitempty"`
+	NonEmpty  bool               `json:"nonEmpty,omitempty"`
+	Env       bool               `json:"env,omitempty"`
+	EnvVar    string             `json:"envVar,omitempty"`
+	Schema    map[string]string  `json:"schema,omitempty"`
+	PercentOf int64              `json:"percentOf,omitempty"`
+	Range     []int64            `json:"range,omitempty"`
+	Layout    string             `json:"layout,omitempty"`
+	Schemes   []string           `json:"schemes,omitempty"`
+	MustExist bool               `json:"mustExist,omitempty"`
+	Fields    []string           `json:"fields,omitempty"`
+	Units     map[string]float64 `json:"units,omitempty"`
+	Canonical string             `json:"canonical,omitempty"`
+	Choices   []string           `json:"choices,omitempty"`
+}
+
+// ExportDefinitions returns the flag definitions of the FlagSet, not
+// their values, as JSON. NewFlagSetFromDefinitions rebuilds a FlagSet
+// from the result. Definitions keep each flag's names, aliases, type,
+// default, usage, group, visibility, requirements and environment
+// binding, along with the help, version and env prefix settings of the
+// FlagSet; other settings, such as the usage style, and subcommands are
+// not exported. Flags with a function attached, a choices, display or
+// validator function, cannot be exported; the fixed choices of
+// AddEnumFlag can.
+func (fs *FlagSet) ExportDefinitions() ([]byte, error) {
+	def := flagSetDefinition{
+		Name:        fs.name,
+		Description: fs.description,
+		Semantics:   fs.semantics,
+		EnvPrefix:   fs.envPrefix,
+		Help:        fs.helpEnabled,
+		Version:     fs.version,
+		Flags:       []flagDefinition{},
+	}
+
+	// Declaration order, so that imported flags are added in the same order
+	flags := sortFlags(fs.flag)
+	sort.SliceStable(flags, func(i, j int) bool {
+		return flags[i].order < flags[j].order
+	})
+	for _, f := range flags {
+		fd, err := fs.exportFlag(f)
+		if err != nil {
+			return nil, err
+		}
+		def.Flags = append(def.Flags, fd)
+	}
+
+	return json.Marshal(def)
+}
+
+// exportFlag returns the serialized definition of a flag
+func (fs *FlagSet) exportFlag(f *Flag) (flagDefinition, error) {
+	fd := flagDefinition{
+		Key:       f.key,
+		ShortName: f.shortName,
+		Aliases:   f.aliases,
+		Type:      f.flagType.String(),
+		Usage:     f.usage,
+		Group:     f.group,
+		Hidden:    f.hidden,
+		Required:  f.required,
+		Requires:  f.requires,
+		NonEmpty:  f.nonEmpty,
+		Env:       f.envBound,
+		EnvVar:    f.envVar,
+		PercentOf: f.percentOf,
+		Range:     f.bounds,
+		Layout:    f.layout,
+		Schemes:   f.schemes,
+	}
+
+	switch {
+	case f.choices != nil:
+		fd.Kind = kindEnum
+		fd.Choices = f.choices
+	case f.choicesFn != nil:
+		return fd, fmt.Errorf("%q: cannot export a choices function", f.key)
+	}
+	if f.display != nil {
+		return fd, fmt.Errorf("%q: cannot export a display function", f.key)
+	}
+	if f.validator != nil {
+		return fd, fmt.Errorf("%q: cannot export a validator function", f.key)
+	}
+	if f.restOfLine {
+		fd.Kind = kindRest
+	}
+
+	switch v := fs.coreFlagSet.Lookup(f.key).Value.(type) {
+	case *globValue:
+		fd.Kind = kindGlob
+	case *linesFileValue:
+		fd.Kind = kindLines
+	case *rangeListValue:
+		fd.Kind = kindRanges
+	case *filePathValue:
+		fd.Kind = kindPath
+		fd.MustExist = v.mustExist
+	case *percentValue:
+		fd.Kind = kindPercent
+	case *sortSpecValue:
+		fd.Kind = kindSort
+		fd.Fields = v.fields
+	case *signedIntValue:
+		fd.Kind = kindSigned
+	case *intFileValue:
+		fd.Kind = kindIntFile
+	case *quantityValue:
+		fd.Kind = kindQuantity
+		fd.Units = v.units
+		fd.Canonical = v.canonical
+	case *stringSliceValue, *semVerValue, *bytesValue, *typedMapValue, *countValue,
+		*ipValue, *timeValue, *intSliceValue, *urlValue, *regexpValue:
+	default:
+		// Only the core flag package's own values remain
+		if reflect.TypeOf(v).Elem().PkgPath() != "flag" {
+			return fd, fmt.Errorf("%q: cannot export flag definition", f.key)
+		}
+	}
+
+	if f.schema != nil {
+		fd.Schema = make(map[string]string, len(f.schema))
+		for k, t := range f.schema {
+			fd.Schema[k] = t.String()
+		}
+	}
+
+	var def interface{} = f.defaultValue
+	switch f.flagType {
+	case DURATION:
+		def = f.defaultValue.(time.Duration).String()
+	case URL:
+		def = nil
+		if u := f.defaultValue.(*url.URL); u != nil {
+			def = u.String()
+		}
+	case REGEXP:
+		def = nil
+		if re := f.defaultValue.(*regexp.Regexp); re != nil {
+			def = re.String()
+		}
+	}
+	if def != nil {
+		raw, err := json.Marshal(def)
+		if err != nil {
+			return fd, fmt.Errorf("%q: %v", f.key, err)
+		}
+		fd.Default = raw
+	}
+
+	return fd, nil
+}
+
+// NewFlagSetFromDefinitions returns a new FlagSet built from flag
+// definitions produced by ExportDefinitions
+func NewFlagSetFromDefinitions(data []byte) (*FlagSet, error) {
+	var def flagSetDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, err
+	}
+
+	fs := NewFlagSet()
+	fs.name = def.Name
+	fs.description = def.Description
+	fs.semantics = def.Semantics
+	fs.envPrefix = def.EnvPrefix
+
+	for _, fd := range def.Flags {
+		if err := fs.importFlag(fd); err != nil {
+			return nil, err
+		}
+	}
+
+	// Help and version flags were imported as plain flags
+	if def.Help {
+		if _, ok := fs.flag["help"]; !ok {
+			return nil, fmt.Errorf("%q: help enabled without flag", "help")
+		}
+		fs.helpEnabled = true
+	}
+	if def.Version != "" {
+		if _, ok := fs.flag["version"]; !ok {
+			return nil, fmt.Errorf("%q: version set without flag", "version")
+		}
+		fs.version = def.Version
+	}
+
+	// Requirements may name flags imported after the flag itself
+	for _, fd := range def.Flags {
+		if len(fd.Requires) == 0 {
+			continue
+		}
+		if err := fs.MarkRequires(fd.Key, fd.Requires...); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// importFlag adds a flag to the FlagSet from its serialized definition
+func (fs *FlagSet) importFlag(fd flagDefinition) error {
+	flagType, ok := parseFlagType(fd.Type)
+	if !ok {
+		return fmt.Errorf("%q: unknown flag type %q", fd.Key, fd.Type)
+	}
+
+	// Decode the default into the Go type backing the flag type
+	def := zeroValue(flagType)
+	if len(fd.Default) > 0 {
+		var err error
+		if def, err = decodeDefault(flagType, fd.Default); err != nil {
+			return fmt.Errorf("%q: invalid default: %v", fd.Key, err)
+		}
+	}
+
+	var err error
+	switch {
+	case fd.Kind == kindGlob:
+		err = fs.AddGlobFlag(fd.Key, fd.ShortName, fd.Usage, def.(string))
+	case fd.Kind == kindLines:
+		err = fs.AddLinesFileFlag(fd.Key, fd.ShortName, fd.Usage)
+	case fd.Kind == kindRanges:
+		err = fs.AddRangeListFlag(fd.Key, fd.ShortName, fd.Usage)
+	case fd.Kind == kindPath:
+		err = fs.AddFilePathFlag(fd.Key, fd.ShortName, fd.Usage, def.(string), fd.MustExist)
+	case fd.Kind == kindPercent:
+		err = fs.AddPercentFlag(fd.Key, fd.ShortName, fd.Usage, def.(float64))
+	case fd.Kind == kindSort:
+		err = fs.AddSortSpecFlag(fd.Key, fd.ShortName, fd.Usage, fd.Fields)
+	case fd.Kind == kindSigned:
+		err = fs.AddSignedIntFlag(fd.Key, fd.ShortName, fd.Usage, def.(int64))
+	case fd.Kind == kindIntFile:
+		err = fs.AddIntFileFlag(fd.Key, fd.ShortName, fd.Usage, def.(int64))
+	case fd.Kind == kindQuantity:
+		err = fs.AddQuantityFlag(fd.Key, fd.ShortName, fd.Usage, fd.Units, fd.Canonical, def.(float64))
+	case fd.Kind == kindEnum:
+		err = fs.AddEnumFlag(fd.Key, fd.ShortName, fd.Usage, fd.Choices, def.(string))
+	case fd.Kind == kindRest:
+		err = fs.AddRestOfLineFlag(fd.Key, fd.ShortName, fd.Usage)
+	case flagType == TYPEDMAP:
+		schema := make(map[string]FlagType, len(fd.Schema))
+		for k, name := range fd.Schema {
+			t, ok := parseFlagType(name)
+			if !ok {
+				return fmt.Errorf("%q: unknown schema type %q", fd.Key, name)
+			}
+			schema[k] = t
+		}
+		err = fs.AddTypedMapFlag(fd.Key, fd.ShortName, fd.Usage, schema)
+	case flagType == SEMVER:
+		err = fs.AddSemVerFlag(fd.Key, fd.ShortName, fd.Usage, def.(string))
+	case flagType == BYTES:
+		err = fs.AddBytesOrPercentFlag(fd.Key, fd.ShortName, fd.Usage, fd.PercentOf, def.(int64))
+	case flagType == STRINGSLICE:
+		err = fs.AddStringSliceFlag(fd.Key, fd.ShortName, fd.Usage, def.([]string))
+	case flagType == INTSLICE:
+		err = fs.AddIntSliceFlag(fd.Key, fd.ShortName, fd.Usage, def.([]int64))
+	case flagType == COUNT:
+		err = fs.AddCountFlag(fd.Key, fd.ShortName, fd.Usage)
+	case flagType == IP:
+		err = fs.AddIPFlag(fd.Key, fd.ShortName, fd.Usage, def.(net.IP))
+	case flagType == TIME:
+		err = fs.AddTimeFlag(fd.Key, fd.ShortName, fd.Usage, fd.Layout, def.(time.Time))
+	case flagType == URL:
+		if err = fs.AddURLFlag(fd.Key, fd.ShortName, fd.Usage, def.(*url.URL)); err == nil && len(fd.Schemes) > 0 {
+			err = fs.SetURLSchemes(fd.Key, fd.Schemes...)
+		}
+	case flagType == REGEXP:
+		err = fs.AddRegexpFlag(fd.Key, fd.ShortName, fd.Usage, def.(*regexp.Regexp))
+	default:
+		if flagType == BASE {
+			def = nil
+		}
+		_, err = fs.addFlag(flagType, fd.Key, fd.ShortName, fd.Usage, def)
+	}
+	if err != nil {
+		return err
+	}
+
+	f := fs.flag[fd.Key]
+	f.required = fd.Required
+	f.nonEmpty = fd.NonEmpty
+	f.hidden = fd.Hidden
+	f.group = fd.Group
+	if flagType == INT && len(fd.Range) == 2 {
+		f.bounds = fd.Range
+	}
+	if fd.Env {
+		if err := fs.BindEnv(fd.Key, fd.EnvVar); err != nil {
+			return err
+		}
+	}
+	for _, alias := range fd.Aliases {
+		if err := fs.AddAlias(fd.Key, alias); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseFlagType returns the flag type with the given name
+func parseFlagType(name string) (FlagType, bool) {
+	for t, n := range flagTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// decodeDefault decodes a serialized default value of a flag type
+func decodeDefault(flagType FlagType, raw json.RawMessage) (interface{}, error) {
+	switch flagType {
+	case BOOL:
+		var v bool
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case INT, BYTES:
+		var v int64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case UINT:
+		var v uint64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case FLOAT:
+		var v float64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case STRING, SEMVER:
+		var v string
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case DURATION:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return time.ParseDuration(v)
+	case STRINGSLICE:
+		var v []string
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case INTSLICE:
+		var v []int64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case IP:
+		var v net.IP
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case TIME:
+		var v time.Time
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case URL:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil || v == "" {
+			return (*url.URL)(nil), err
+		}
+		return url.Parse(v)
+	case REGEXP:
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil || v == "" {
+			return (*regexp.Regexp)(nil), err
+		}
+		return regexp.Compile(v)
+	}
+	return zeroValue(flagType), nil
+}
@@ -0,0 +1,159 @@
+package flagplus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFlagSet_ExportDefinitions(t *testing.T) {
+	flags := NewFlagSet("util", "utility")
+	flags.FlagSetDescription("Utility that does stuff")
+	flags.AddSemantics("files...")
+	flags.AddFlag("help", "h", "Help")
+	flags.AddBoolFlag("verbose", "v", "Print extra debugging information", true)
+	flags.AddIntFlag("line", "l", "Start counting at `line_number`", 1)
+	flags.AddFloatFlag("skew", "s", "Skew `percentage`", 2.33)
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.AddDurationFlag("timeout", "t", "Request timeout", 90*time.Second)
+	flags.AddUintFlag("port", "p", "Listen port", 8080)
+	flags.AddStringSliceFlag("include", "I", "Include `directory`", []string{"a", "b"})
+	flags.AddSemVerFlag("min-version", "m", "Minimum version", "1.2.3")
+	flags.AddBytesOrPercentFlag("cache", "c", "Cache size", 1000, 100)
+	flags.AddGlobFlag("pattern", "g", "Files to match", "*.log")
+	flags.AddTypedMapFlag("param", "P", "Parameters", map[string]FlagType{"retries": INT})
+	flags.SetRequired("output")
+
+	data, err := flags.ExportDefinitions()
+	if err != nil {
+		t.Fatalf("Could not export definitions: %v", err)
+	}
+
+	got, err := NewFlagSetFromDefinitions(data)
+	if err != nil {
+		t.Fatalf("Could not import definitions: %v", err)
+	}
+
+	if got.Usage() != flags.Usage() {
+		t.Errorf("Expected usage %q, got %q", flags.Usage(), got.Usage())
+	}
+	if !got.flag["output"].required {
+		t.Error("Expected required flag to be preserved")
+	}
+
+	again, err := got.ExportDefinitions()
+	if err != nil {
+		t.Fatalf("Could not export imported definitions: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("Expected round trip %s, got %s", data, again)
+	}
+
+	// Choice functions cannot be serialized
+	flags.AddStringChoiceFuncFlag("codec", "C", "Codec", "", func() []string { return nil })
+	if _, err := flags.ExportDefinitions(); err == nil {
+		t.Error("Expected error exporting a choices function")
+	}
+}
+
+func TestFlagSet_ExportDefinitions_Metadata(t *testing.T) {
+	flags := NewFlagSet("util", "utility")
+	flags.EnableHelp()
+	flags.EnableVersion("1.0.0")
+	flags.SetEnvPrefix("UTIL")
+	flags.AddStringFlag("output", "o", "Output `directory`", "out")
+	flags.AddSortSpecFlag("order", "", "Sort order", []string{"name", "date"})
+	flags.AddSignedIntFlag("offset", "", "Offset", -3)
+	flags.AddIntFileFlag("limit", "", "Limit", 10)
+	flags.AddQuantityFlag("weight", "", "Weight", map[string]float64{"g": 1, "kg": 1000}, "g", 500)
+	flags.AddRestOfLineFlag("message", "m", "Message")
+	flags.AddEnumFlag("mode", "", "Run mode", []string{"fast", "safe"}, "safe")
+	flags.AddStringFlag("cert", "", "Certificate", "")
+	flags.AddBoolFlag("tls", "", "Use TLS", false)
+	flags.AddAlias("output", "out-dir")
+	flags.MarkHidden("offset")
+	flags.SetGroup("tls", "Network")
+	flags.SetGroup("cert", "Network")
+	flags.BindEnv("output", "")
+	flags.BindEnv("limit", "LIMIT")
+	flags.MarkRequires("tls", "cert")
+
+	data, err := flags.ExportDefinitions()
+	if err != nil {
+		t.Fatalf("Could not export definitions: %v", err)
+	}
+	got, err := NewFlagSetFromDefinitions(data)
+	if err != nil {
+		t.Fatalf("Could not import definitions: %v", err)
+	}
+
+	if got.Usage() != flags.Usage() {
+		t.Errorf("Expected usage %q, got %q", flags.Usage(), got.Usage())
+	}
+	again, err := got.ExportDefinitions()
+	if err != nil {
+		t.Fatalf("Could not export imported definitions: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("Expected round trip %s, got %s", data, again)
+	}
+
+	// The imported flags behave like the originals
+	t.Setenv("UTIL_OUTPUT", "/tmp")
+	t.Setenv("LIMIT", "20")
+	got.SetOutput(new(bytes.Buffer))
+	err = got.Parse("util", "--order", "-date", "--offset", "-5", "--weight", "2kg", "--tls", "--cert", "c.pem", "-m", "hello", "world")
+	if err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if v, _ := got.GetString("out-dir"); v != "/tmp" {
+		t.Errorf("Expected %q, got %q", "/tmp", v)
+	}
+	if v, _ := got.GetAsString("limit"); v != "20" {
+		t.Errorf("Expected %q, got %q", "20", v)
+	}
+	if v, _ := got.GetAsString("order"); v != "-date" {
+		t.Errorf("Expected %q, got %q", "-date", v)
+	}
+	if v, _ := got.GetAsString("offset"); v != "-5" {
+		t.Errorf("Expected %q, got %q", "-5", v)
+	}
+	if v, _ := got.GetAsString("weight"); v != "2000" {
+		t.Errorf("Expected %q, got %q", "2000", v)
+	}
+	if v, _ := got.GetString("message"); v != "hello world" {
+		t.Errorf("Expected %q, got %q", "hello world", v)
+	}
+	if v, _ := got.GetString("mode"); v != "safe" {
+		t.Errorf("Expected %q, got %q", "safe", v)
+	}
+	got.Reset()
+	if err := got.Parse("util", "--mode", "turbo"); err == nil {
+		t.Error("Expected error for invalid choice")
+	}
+
+	got.Reset()
+	if err := got.Parse("util", "--version"); err != ErrVersion {
+		t.Errorf("Expected ErrVersion, got %v", err)
+	}
+	got.Reset()
+	if err := got.Parse("util", "--tls"); err == nil {
+		t.Error("Expected error for missing required flag")
+	}
+}
+
+func TestFlagSet_ExportDefinitions_Functions(t *testing.T) {
+	flags := NewFlagSet("util", "utility")
+	flags.AddStringFlag("output", "o", "Output", "")
+	flags.SetDisplayFunc("output", func(v interface{}) string { return "" })
+	if _, err := flags.ExportDefinitions(); err == nil {
+		t.Error("Expected error exporting a display function")
+	}
+
+	flags = NewFlagSet("util", "utility")
+	flags.AddStringFlag("output", "o", "Output", "")
+	flags.SetValidator("output", func(v interface{}) error { return nil })
+	if _, err := flags.ExportDefinitions(); err == nil {
+		t.Error("Expected error exporting a validator function")
+	}
+}
@@ -9,12 +9,69 @@
 package flagplus
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// ErrHelp is returned by Parse when help was requested with a flag
+// registered by EnableHelp
+var ErrHelp = errors.New("flagplus: help requested")
+
+// ErrVersion is returned by Parse when the version was requested with
+// a flag registered by EnableVersion
+var ErrVersion = errors.New("flagplus: version requested")
+
+// defaultUsageWidth is the column usage descriptions wrap at
+const defaultUsageWidth = 80
+
+// defaultSynopsisThreshold is the flag count above which the usage
+// synopsis collapses to "[options]"
+const defaultSynopsisThreshold = 6
+
+// Usage styles for SetUsageStyle
+const (
+	// UsageStyleDefault lists all options under "Options:"
+	UsageStyleDefault = "default"
+	// UsageStyleRequiredFirst lists required options under "Required:"
+	// before the rest under "Optional:"
+	UsageStyleRequiredFirst = "required-first"
+)
+
+// Orders of flags for SetUsageSort
+const (
+	// UsageSortAlphabetical lists flags in lexicographical order of key
+	UsageSortAlphabetical = "alphabetical"
+	// UsageSortDeclaration lists flags in the order they were added
+	UsageSortDeclaration = "declaration"
+)
+
+// Sources of flag values reported by PrecedenceChain, from lowest to
+// highest precedence
+const (
+	// SourceDefault is the default given when the flag was added
+	SourceDefault = "default"
+	// SourceConfig is a default loaded by LoadDefaults
+	SourceConfig = "config"
+	// SourceEnv is an environment variable bound by BindEnv
+	SourceEnv = "env"
+	// SourceCLI is the command line
+	SourceCLI = "cli"
 )
 
 // FlagType holds the type of the flag
@@ -31,54 +88,288 @@ const (
 	FLOAT
 	// STRING is a string flag
 	STRING
+	// TYPEDMAP is a list of key=value pairs coerced per a schema
+	TYPEDMAP
+	// SEMVER is a semantic version string
+	SEMVER
+	// BYTES is a byte count given as a size such as 10MB
+	BYTES
+	// DURATION is a time.Duration flag such as 1h30m
+	DURATION
+	// STRINGSLICE is a list of strings
+	STRINGSLICE
+	// UINT is an unsigned integer flag
+	UINT
+	// SORTSPEC is an ordered list of sort fields such as name,-date
+	SORTSPEC
+	// INTSLICE is a list of integers
+	INTSLICE
+	// COUNT is the number of times a flag is given, as in -vvv
+	COUNT
+	// IP is an IPv4 or IPv6 address
+	IP
+	// TIME is a timestamp in a given layout
+	TIME
+	// URL is an absolute URL
+	URL
+	// REGEXP is a compiled regular expression
+	REGEXP
 )
 
+// flagTypeNames holds the name of each flag type
+var flagTypeNames = map[FlagType]string{
+	BASE:        "BASE",
+	BOOL:        "BOOL",
+	INT:         "INT",
+	FLOAT:       "FLOAT",
+	STRING:      "STRING",
+	TYPEDMAP:    "TYPEDMAP",
+	SEMVER:      "SEMVER",
+	BYTES:       "BYTES",
+	DURATION:    "DURATION",
+	STRINGSLICE: "STRINGSLICE",
+	UINT:        "UINT",
+	SORTSPEC:    "SORTSPEC",
+	INTSLICE:    "INTSLICE",
+	COUNT:       "COUNT",
+	IP:          "IP",
+	TIME:        "TIME",
+	URL:         "URL",
+	REGEXP:      "REGEXP",
+}
+
+// String implements the fmt.Stringer interface for FlagType
+func (t FlagType) String() string {
+	if name, ok := flagTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("FlagType(%d)", int(t))
+}
+
 // Flag represents the state of a flag
 type Flag struct {
-	key          string      // Key to the map index, also the long name
-	shortName    string      // Short name as it appears on command line
-	flagType     FlagType    // The type of the flag
-	value        interface{} // The value as set
-	defaultValue interface{} // Holds the dynamic value of the flag (for usage)
-	usage        string      // Usage statement
+	key          string                         // Key to the map index, also the long name
+	shortName    string                         // Short name as it appears on command line
+	flagType     FlagType                       // The type of the flag
+	value        interface{}                    // The value as set
+	defaultValue interface{}                    // Holds the dynamic value of the flag (for usage)
+	usage        string                         // Usage statement
+	isSet        bool                           // Was the flag set on the command line?
+	choicesFn    func() []string                // Optional source of permitted values
+	schema       map[string]FlagType            // Value types of TYPEDMAP keys
+	nonEmpty     bool                           // Is an explicitly empty value an error?
+	display      func(value interface{}) string // Optional renderer for values
+	percentOf    int64                          // Total that BYTES percentages are relative to
+	required     bool                           // Must the flag be given?
+	placeholder  string                         // Usage placeholder overriding the type name
+	envBound     bool                           // Is the flag bound to an environment variable?
+	envVar       string                         // Explicitly bound environment variable name
+	envSet       bool                           // Was the value taken from the environment?
+	note         string                         // Usage text describing accepted values
+	bounds       []int64                        // Inclusive [min, max] of a range-bounded INT
+	layers       []layer                        // Values provided by each source, lowest precedence first
+	requires     []string                       // Keys of flags that must accompany this one
+	restOfLine   bool                           // Does the flag take all remaining arguments as its value?
+	hidden       bool                           // Is the flag left out of Usage?
+	validator    func(value interface{}) error  // Optional check of the parsed value
+	baseDefault  interface{}                    // Default given when added, once LoadDefaults replaces it
+	loaded       bool                           // Was the default loaded by LoadDefaults?
+	layout       string                         // Layout of TIME values
+	group        string                         // Usage heading the flag is listed under
+	aliases      []string                       // Additional long names
+	schemes      []string                       // Schemes allowed in URL values
+	order        int                            // Position among the flags in the order added
+	requiredFor  []string                       // Subcommands the flag is required for
+	choices      []string                       // Fixed choices of an enum flag
 }
 
-// FlagSet represents a set of defined flags
+// FlagSet represents a set of defined flags. Once Parse has returned,
+// flag values may be read concurrently from multiple goroutines.
 type FlagSet struct {
-	isParsed    bool             // Has the FlagSet been parsed using the Parse() func?
-	coreFlagSet flag.FlagSet     // Core FlagSet
-	flag        map[string]*Flag // Flags in the FlagSet
-	name        string           // Optional name of the flag set
-	description string           // Optional description of command line
-	semantics   string           // Semantic description of arguments after flags
+	isParsed      bool                    // Has the FlagSet been parsed using the Parse() func?
+	coreFlagSet   flag.FlagSet            // Core FlagSet
+	flag          map[string]*Flag        // Flags in the FlagSet
+	name          string                  // Optional name of the flag set
+	description   string                  // Optional description of command line
+	semantics     string                  // Semantic description of arguments after flags
+	synopsisMax   int                     // Flag count above which the synopsis collapses
+	postParse     func(fs *FlagSet) error // Optional hook run after a successful parse
+	strictIndex   bool                    // Are gaps in indexed flags (--name.N) errors?
+	examples      []example               // Example invocations shown in usage
+	usageStyle    string                  // Layout of the usage options
+	allowReparse  bool                    // May Parse be called more than once?
+	envPrefix     string                  // Prefix of derived environment variable names
+	output        io.Writer               // Destination for usage and error messages
+	defaultArgs   []string                // Arguments parsed when none are given
+	subcommands   map[string]*FlagSet     // Nested FlagSets by subcommand name
+	activeSub     string                  // Subcommand chosen by ParseWithSubcommands
+	helpEnabled   bool                    // Does --help print usage and stop Parse?
+	version       string                  // Version printed by --version
+	errorHandling flag.ErrorHandling      // What Parse does on error
+	mu            sync.RWMutex            // Guards flag values during Parse and reads
+	prefixMatch   bool                    // May long names be abbreviated to a unique prefix?
+	caseFold      bool                    // Are long names matched ignoring case?
+	usageWidth    int                     // Column usage descriptions wrap at, or 0
+	positionals   []positional            // Declared positional arguments
+	posValues     map[string][]string     // Positional values by name after Parse
+	argRange      []int                   // Minimum and maximum argument count, if set
+	responseFiles bool                    // Are @file arguments expanded?
+	usageTemplate *template.Template      // Replaces the built-in usage format
+	color         *bool                   // Is usage colored? nil detects a terminal
+	usageSort     string                  // Order of flags in usage
+	helpCommand   bool                    // Is "help" a built-in subcommand?
+	simulated     []string                // Flags set by SimulateArg since the last Parse
+}
+
+// example is a described example invocation of the command line
+type example struct {
+	description string
+	commandLine string
+}
+
+// StateSnapshot holds the values and set-state of every flag in a
+// FlagSet at a point in time
+type StateSnapshot struct {
+	owner *FlagSet               // FlagSet the snapshot was taken from
+	value map[string]interface{} // Flag values by key
+	isSet map[string]bool        // Flag set-state by key
+}
+
+// SourceValue is the value a single source provided for a flag
+type SourceValue struct {
+	Source string // One of SourceDefault, SourceConfig, SourceEnv or SourceCLI
+	Value  string // The value rendered as by GetAsString
+}
+
+// layer is the value a source provided for a flag. Values are only
+// rendered by PrecedenceChain, so that display functions run without
+// the FlagSet locked.
+type layer struct {
+	source   string
+	value    interface{} // The value, or its text if verbatim
+	verbatim bool
+}
+
+// SortField is one field of a sort specification
+type SortField struct {
+	Field      string // Name of the field
+	Descending bool   // Was the field prefixed with "-"?
+}
+
+// ParseError is returned by Parse when the command line is rejected
+// by the core flag package, such as for an unknown flag or a value
+// that does not parse
+type ParseError struct {
+	FlagSet string // Name of the FlagSet
+	Flag    string // Name of the offending flag, if known
+	Err     error  // Error from the core flag package
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Could not parse FlagSet %q: %v", e.FlagSet, e.Err)
+}
+
+// Unwrap returns the error from the core flag package
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// coreErrorFlag matches the flag name in errors of the core flag package
+var coreErrorFlag = regexp.MustCompile(`: -(\S+)$| for (?:flag )?-([^\s:]+): `)
+
+// errorFlagName returns the flag named by an error of the core flag
+// package, or "" if it names none
+func errorFlagName(err error) string {
+	m := coreErrorFlag.FindStringSubmatch(err.Error())
+	if m == nil {
+		return ""
+	}
+	return m[1] + m[2]
 }
 
 // String implements fmt.string interface for Flag
 func (f *Flag) String() string {
-	var s, typeStr, defStr string
+	var s, defStr string
 	switch f.flagType {
 	case BASE:
-		typeStr = "BASE"
 		defStr = "n/a"
 	case BOOL:
-		typeStr = "BOOL"
 		defStr = strconv.FormatBool(f.defaultValue.(bool))
 	case INT:
-		typeStr = "INT"
 		defStr = fmt.Sprintf("%d", f.defaultValue.(int64))
 	case FLOAT:
-		typeStr = "FLOAT"
 		defStr = fmt.Sprintf("%f", f.defaultValue.(float64))
 	case STRING:
-		typeStr = "STRING"
 		defStr = f.defaultValue.(string)
+	case TYPEDMAP:
+		defStr = "n/a"
+	case SEMVER:
+		defStr = f.defaultValue.(string)
+	case BYTES:
+		defStr = fmt.Sprintf("%d", f.defaultValue.(int64))
+	case DURATION:
+		defStr = f.defaultValue.(time.Duration).String()
+	case STRINGSLICE:
+		defStr = strings.Join(f.defaultValue.([]string), ",")
+	case UINT:
+		defStr = fmt.Sprintf("%d", f.defaultValue.(uint64))
+	case SORTSPEC:
+		defStr = "n/a"
+	case INTSLICE:
+		defStr = formatInts(f.defaultValue.([]int64))
+	case COUNT:
+		defStr = fmt.Sprintf("%d", f.defaultValue.(int))
+	case IP:
+		defStr = f.defaultValue.(net.IP).String()
+	case TIME:
+		defStr = f.defaultValue.(time.Time).Format(f.layout)
+	case URL:
+		defStr = formatURL(f.defaultValue.(*url.URL))
+	case REGEXP:
+		defStr = formatRegexp(f.defaultValue.(*regexp.Regexp))
 	}
 	s += fmt.Sprintf("TYPE=%s shortName=%q usage=%q default=%q\n",
-		typeStr, f.shortName, f.usage, defStr)
+		f.flagType, f.shortName, f.usage, defStr)
 
 	return s
 }
 
+// Key returns the key of the flag, which is also its long name
+func (f *Flag) Key() string {
+	return f.key
+}
+
+// ShortName returns the short name of the flag
+func (f *Flag) ShortName() string {
+	return f.shortName
+}
+
+// Type returns the type of the flag
+func (f *Flag) Type() FlagType {
+	return f.flagType
+}
+
+// Usage returns the usage statement of the flag
+func (f *Flag) Usage() string {
+	return f.usage
+}
+
+// TypeName returns the name of the flag's value as shown in usage, such
+// as "int" or a name given in `backquotes` in the usage statement
+func (f *Flag) TypeName() string {
+	name, _ := unquoteUsage(f)
+	return name
+}
+
+// Default returns the default of the flag as it would be given on the
+// command line, or "" if it has none
+func (f *Flag) Default() string {
+	if !f.hasDefault() {
+		return ""
+	}
+	return f.format(f.defaultValue)
+}
+
 // String implements the fmt.string interface for FlagSet
 func (fs *FlagSet) String() string {
 	var s string
@@ -97,192 +388,2445 @@ func (fs *FlagSet) AddSemantics(desc string) {
 }
 
 // AddStringFlag adds a string flag to a FlagSet
-func (fs *FlagSet) AddStringFlag(key, shortName, usage string, defaultValue string) {
-	fs.addFlag(
+func (fs *FlagSet) AddStringFlag(key, shortName, usage string, defaultValue string) error {
+	_, err := fs.addFlag(
 		STRING,
 		key,
 		shortName,
 		usage,
 		defaultValue,
 	)
+	return err
 }
 
-// AddFloatFlag adds a float flag to a FlagSet
-func (fs *FlagSet) AddFloatFlag(key, shortName, usage string, defaultValue float64) {
-	fs.addFlag(
-		FLOAT,
+// AddGlobFlag adds a string flag holding a glob pattern in the syntax
+// of filepath.Match. Malformed patterns are parse errors.
+func (fs *FlagSet) AddGlobFlag(key, shortName, usage string, defaultValue string) error {
+	if _, err := filepath.Match(defaultValue, ""); err != nil {
+		return fmt.Errorf("%q: invalid default glob %q", key, defaultValue)
+	}
+
+	v := defaultValue
+	f, err := fs.addVar(
+		STRING,
 		key,
 		shortName,
 		usage,
 		defaultValue,
+		&v,
+		&globValue{p: &v},
 	)
+	if err != nil {
+		return err
+	}
+	f.placeholder = "glob"
+	return nil
 }
 
-// AddFlag adds a base flag to a FlagSet
-func (fs *FlagSet) AddFlag(key, shortName, usage string) {
-	fs.addFlag(
-		BASE,
+// AddFilePathFlag adds a string flag holding a file path. If mustExist
+// is set, paths that do not name an existing regular file, such as
+// directories, are parse errors. The default is not checked.
+func (fs *FlagSet) AddFilePathFlag(key, shortName, usage string, defaultValue string, mustExist bool) error {
+	v := defaultValue
+	f, err := fs.addVar(
+		STRING,
 		key,
 		shortName,
 		usage,
-		nil,
+		defaultValue,
+		&v,
+		&filePathValue{p: &v, mustExist: mustExist},
 	)
+	if err != nil {
+		return err
+	}
+	f.placeholder = "path"
+	return nil
 }
 
-// AddIntFlag adds an integer flag to a FlagSet
-func (fs *FlagSet) AddIntFlag(key, shortName, usage string, defaultValue int64) {
-	fs.addFlag(
-		INT,
+// AddStringChoiceFuncFlag adds a string flag whose permitted values
+// are computed by choicesFn when the FlagSet is parsed
+func (fs *FlagSet) AddStringChoiceFuncFlag(key, shortName, usage string, defaultValue string, choicesFn func() []string) error {
+	f, err := fs.addFlag(
+		STRING,
 		key,
 		shortName,
 		usage,
 		defaultValue,
 	)
+	if err != nil {
+		return err
+	}
+	f.choicesFn = choicesFn
+	return nil
 }
 
-// AddBoolFlag adds a boolean flag to a FlagSet
-func (fs *FlagSet) AddBoolFlag(key, shortName, usage string, defaultValue bool) {
-	fs.addFlag(
-		BOOL,
+// AddEnumFlag adds a string flag that must be one of a fixed set of
+// choices. The default must itself be one of the choices.
+func (fs *FlagSet) AddEnumFlag(key, shortName, usage string, choices []string, defaultValue string) error {
+	allowed := append([]string(nil), choices...)
+	valid := false
+	for _, c := range allowed {
+		if c == defaultValue {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("%q: default %q is not one of [%s]",
+			key, defaultValue, strings.Join(allowed, "|"))
+	}
+
+	err := fs.AddStringChoiceFuncFlag(key, shortName, usage, defaultValue, func() []string {
+		return allowed
+	})
+	if err != nil {
+		return err
+	}
+	fs.flag[key].choices = allowed
+	return nil
+}
+
+// AddTypedMapFlag adds a flag holding comma separated key=value pairs.
+// Each value is coerced to the type the schema declares for its key;
+// unknown keys and failed coercions are parse errors.
+func (fs *FlagSet) AddTypedMapFlag(key, shortName, usage string, schema map[string]FlagType) error {
+	m := make(map[string]interface{})
+	f, err := fs.addVar(
+		TYPEDMAP,
 		key,
 		shortName,
 		usage,
-		defaultValue,
+		nil,
+		&m,
+		&typedMapValue{m: &m, schema: schema},
 	)
-}
-
-// addFlag adds a new flag to a FlagSet
-func (fs *FlagSet) addFlag(
-	flagType FlagType,
-	key, shortName, usage string,
-	defaultValue interface{}) {
-
-	newFlag := new(Flag)
-	newFlag.key = key
-	newFlag.flagType = flagType
-	newFlag.shortName = shortName
-	newFlag.defaultValue = defaultValue
-	newFlag.usage = usage
-
-	// Initialize values in core.flag
-	switch flagType {
-	case BASE:
-		newFlag.value = fs.coreFlagSet.Bool(key, false, usage)
-		fs.coreFlagSet.BoolVar(newFlag.value.(*bool), shortName, false, usage)
-	case BOOL:
-		newFlag.value = fs.coreFlagSet.Bool(key, defaultValue.(bool), usage)
-		fs.coreFlagSet.BoolVar(newFlag.value.(*bool), shortName, defaultValue.(bool), usage)
-	case INT:
-		newFlag.value = fs.coreFlagSet.Int64(key, defaultValue.(int64), usage)
-		fs.coreFlagSet.Int64Var(newFlag.value.(*int64), shortName, defaultValue.(int64), usage)
-	case FLOAT:
-		newFlag.value = fs.coreFlagSet.Float64(key, defaultValue.(float64), usage)
-		fs.coreFlagSet.Float64Var(newFlag.value.(*float64), shortName, defaultValue.(float64), usage)
-	case STRING:
-		newFlag.value = fs.coreFlagSet.String(key, defaultValue.(string), usage)
-		fs.coreFlagSet.StringVar(newFlag.value.(*string), shortName, defaultValue.(string), usage)
+	if err != nil {
+		return err
 	}
-
-	// Assign flag to FlagSet map
-	fs.flag[key] = newFlag
+	f.schema = schema
+	return nil
 }
 
-// GetArgs returns the arguments after flags
-func (fs *FlagSet) GetArgs() []string {
-	return fs.coreFlagSet.Args()
+// AddSemVerFlag adds a semantic version flag, major.minor.patch with
+// optional pre-release and build metadata. Invalid versions are parse
+// errors.
+func (fs *FlagSet) AddSemVerFlag(key, shortName, usage string, defaultValue string) error {
+	v := defaultValue
+	_, err := fs.addVar(
+		SEMVER,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&semVerValue{p: &v},
+	)
+	return err
 }
 
-// Get returns a basic flag value
-func (fs *FlagSet) Get(key string) (bool, error) {
-	if err := fs.flagCheck(key, BASE); err != nil {
-		return false, err
-	}
-
-	return *fs.flag[key].value.(*bool), nil
+// AddBytesFlag adds a byte count flag accepting a size such as 1024,
+// 10MB or 1GiB. Bare numbers are bytes.
+func (fs *FlagSet) AddBytesFlag(key, shortName, usage string, defaultValue int64) error {
+	v := defaultValue
+	_, err := fs.addVar(
+		BYTES,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&bytesValue{p: &v},
+	)
+	return err
 }
 
-// GetBool returns a boolean flag value
-func (fs *FlagSet) GetBool(key string) (bool, error) {
-	if err := fs.flagCheck(key, BOOL); err != nil {
-		return false, err
+// AddBytesOrPercentFlag adds a byte count flag accepting either a size,
+// such as 512MB or 1GiB, or a percentage of total, such as 25%
+func (fs *FlagSet) AddBytesOrPercentFlag(key, shortName, usage string, total int64, defaultValue int64) error {
+	v := defaultValue
+	f, err := fs.addVar(
+		BYTES,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&bytesValue{p: &v, total: total},
+	)
+	if err != nil {
+		return err
 	}
-
-	return *fs.flag[key].value.(*bool), nil
+	f.percentOf = total
+	return nil
 }
 
-// GetInt returns an integer flag value
-func (fs *FlagSet) GetInt(key string) (int64, error) {
-	if err := fs.flagCheck(key, INT); err != nil {
-		return 0, err
+// AddPercentFlag adds a float flag holding a percentage from 0 to 100,
+// given as either 25 or 25%
+func (fs *FlagSet) AddPercentFlag(key, shortName, usage string, defaultValue float64) error {
+	if math.IsNaN(defaultValue) || defaultValue < 0 || defaultValue > 100 {
+		return fmt.Errorf("%q: default %v out of range [0,100]", key, defaultValue)
 	}
 
-	return *fs.flag[key].value.(*int64), nil
+	v := defaultValue
+	f, err := fs.addVar(
+		FLOAT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&percentValue{p: &v},
+	)
+	if err != nil {
+		return err
+	}
+	f.placeholder = "percent"
+	return nil
 }
 
-// GetFloat returns a float flag value
-func (fs *FlagSet) GetFloat(key string) (float64, error) {
-	if err := fs.flagCheck(key, FLOAT); err != nil {
-		return 0.00, err
-	}
+// AddDurationFlag adds a duration flag to a FlagSet. Values are parsed
+// by time.ParseDuration, e.g. "30s" or "1h30m".
+func (fs *FlagSet) AddDurationFlag(key, shortName, usage string, defaultValue time.Duration) error {
+	_, err := fs.addFlag(
+		DURATION,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+	)
+	return err
+}
 
-	return *fs.flag[key].value.(*float64), nil
+// AddStringSliceFlag adds a repeatable string flag to a FlagSet. Each
+// use of the flag appends to the slice, in command line order whether
+// the long or short name is used; the first use replaces the default.
+func (fs *FlagSet) AddStringSliceFlag(key, shortName, usage string, defaultValue []string) error {
+	v := append([]string(nil), defaultValue...)
+	_, err := fs.addVar(
+		STRINGSLICE,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&stringSliceValue{p: &v},
+	)
+	return err
 }
 
-// GetString returns a string flag value
-func (fs *FlagSet) GetString(key string) (string, error) {
-	if err := fs.flagCheck(key, STRING); err != nil {
-		return "", err
-	}
+// AddLinesFileFlag adds a string slice flag whose values are read from
+// files. Each use of the flag names a file whose lines are appended,
+// trimmed, skipping blank lines and lines beginning with "#".
+func (fs *FlagSet) AddLinesFileFlag(key, shortName, usage string) error {
+	var v []string
+	_, err := fs.addVar(
+		STRINGSLICE,
+		key,
+		shortName,
+		usage,
+		[]string(nil),
+		&v,
+		&linesFileValue{p: &v},
+	)
+	return err
+}
 
-	return *fs.flag[key].value.(*string), nil
+// AddFloatFlag adds a float flag to a FlagSet
+func (fs *FlagSet) AddFloatFlag(key, shortName, usage string, defaultValue float64) error {
+	_, err := fs.addFlag(
+		FLOAT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+	)
+	return err
 }
 
-// flagCheck inspects the flag map by key for presence, type and
-// if being requested prior to parse
-func (fs *FlagSet) flagCheck(key string, flagType FlagType) error {
-	// Has the flag set been parsed?
-	if !fs.isParsed {
-		return fmt.Errorf("FlagSet %q has not been parsed", fs.name)
+// AddQuantityFlag adds a float flag given with a unit suffix, such as
+// "5m" or "300s". units maps each suffix to its multiplier relative to
+// the canonical unit; the value is converted to the canonical unit and
+// read with GetFloat. A bare number is taken as canonical. Unknown
+// units are parse errors.
+func (fs *FlagSet) AddQuantityFlag(key, shortName, usage string, units map[string]float64, canonical string, defaultValue float64) error {
+	v := defaultValue
+	f, err := fs.addVar(
+		FLOAT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&quantityValue{p: &v, units: units, canonical: canonical},
+	)
+	if err != nil {
+		return err
 	}
 
-	// Check if key exists
-	if _, ok := fs.flag[key]; !ok {
-		return fmt.Errorf("%q: flag does not exist", key)
+	suffixes := make([]string, 0, len(units))
+	for u := range units {
+		suffixes = append(suffixes, u)
 	}
+	sort.Strings(suffixes)
+	f.placeholder = "quantity"
+	f.note = fmt.Sprintf("units: %s; in %s", strings.Join(suffixes, ", "), canonical)
+	return nil
+}
 
-	// Check if the flag type matches expectation
-	if fs.flag[key].flagType != flagType {
-		return fmt.Errorf("%q: incorrect flag type", key)
+// AddSortSpecFlag adds a flag holding a comma separated list of sort
+// fields, such as "name,-date". Each field must be one of fields and
+// may be prefixed with "-" for descending order. Read the value with
+// GetSortSpec.
+func (fs *FlagSet) AddSortSpecFlag(key, shortName, usage string, fields []string) error {
+	var spec []SortField
+	f, err := fs.addVar(
+		SORTSPEC,
+		key,
+		shortName,
+		usage,
+		nil,
+		&spec,
+		&sortSpecValue{p: &spec, fields: append([]string(nil), fields...)},
+	)
+	if err != nil {
+		return err
 	}
-
+	f.note = fmt.Sprintf("fields: %s; prefix with - for descending", strings.Join(fields, ", "))
 	return nil
 }
 
-// FlagSetDescription sets the optional description of the FlagSet
-func (fs *FlagSet) FlagSetDescription(description string) {
-	fs.description = description
+// AddIntSliceFlag adds a flag holding a list of integers, given comma
+// separated, repeated, or both, as in "--port 80,443 --port 8080". The
+// first use replaces the default.
+func (fs *FlagSet) AddIntSliceFlag(key, shortName, usage string, defaultValue []int64) error {
+	v := append([]int64(nil), defaultValue...)
+	_, err := fs.addVar(
+		INTSLICE,
+		key,
+		shortName,
+		usage,
+		append([]int64(nil), defaultValue...),
+		&v,
+		&intSliceValue{p: &v},
+	)
+	return err
 }
 
-// SimulateArg allows the test suite to simulate command-line arguments
-func (fs *FlagSet) SimulateArg(name string, value string) error {
-	return fs.coreFlagSet.Set(name, value)
+// AddRangeListFlag adds a flag holding a comma separated list of
+// integers and inclusive ranges, such as "1-3,5,7-9". The value is
+// expanded into a sorted list without duplicates and read with
+// GetIntSlice. Repeated uses add to the list.
+func (fs *FlagSet) AddRangeListFlag(key, shortName, usage string) error {
+	var list []int64
+	f, err := fs.addVar(
+		INTSLICE,
+		key,
+		shortName,
+		usage,
+		[]int64(nil),
+		&list,
+		&rangeListValue{p: &list},
+	)
+	if err != nil {
+		return err
+	}
+	f.placeholder = "ranges"
+	return nil
 }
 
-// Parse parses flag definitions
-func (fs *FlagSet) Parse(args ...string) error {
-	if len(args) > 0 {
-		os.Args = args
-	}
-	err := fs.coreFlagSet.Parse(os.Args[1:])
+// AddRestOfLineFlag adds a string flag whose value is every argument
+// following it, joined by spaces, so that a message such as
+// "--message hello world" needs no quoting. Arguments are taken up to
+// the end of the command line or a "--" terminator, so no other flag
+// may follow it.
+func (fs *FlagSet) AddRestOfLineFlag(key, shortName, usage string) error {
+	f, err := fs.addFlag(
+		STRING,
+		key,
+		shortName,
+		usage,
+		"",
+	)
 	if err != nil {
-		return fmt.Errorf("Could not parse FlagSet %q", fs.name)
+		return err
 	}
-	fs.isParsed = true
+	f.restOfLine = true
+	f.placeholder = "text..."
 	return nil
 }
 
-func unquoteUsage(flag *Flag) (name string, usage string) {
-	usage = flag.usage
+// AddCountFlag adds a flag counting how often it is given, so that
+// "-v -v -v" and "-vvv" both count 3. Read the count with GetCount.
+func (fs *FlagSet) AddCountFlag(key, shortName, usage string) error {
+	var n int
+	f, err := fs.addVar(
+		COUNT,
+		key,
+		shortName,
+		usage,
+		0,
+		&n,
+		&countValue{p: &n},
+	)
+	if err != nil {
+		return err
+	}
+	f.note = "repeatable"
+	return nil
+}
+
+// AddIPFlag adds an IPv4 or IPv6 address flag to a FlagSet
+func (fs *FlagSet) AddIPFlag(key, shortName, usage string, defaultValue net.IP) error {
+	v := append(net.IP(nil), defaultValue...)
+	_, err := fs.addVar(
+		IP,
+		key,
+		shortName,
+		usage,
+		append(net.IP(nil), defaultValue...),
+		&v,
+		&ipValue{p: &v},
+	)
+	return err
+}
+
+// AddTimeFlag adds a timestamp flag parsed with layout, as for
+// time.Parse. An empty layout is time.RFC3339. Usage shows the layout.
+func (fs *FlagSet) AddTimeFlag(key, shortName, usage, layout string, defaultValue time.Time) error {
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	v := defaultValue
+	f, err := fs.addVar(
+		TIME,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&timeValue{p: &v, layout: layout},
+	)
+	if err != nil {
+		return err
+	}
+	f.layout = layout
+	f.note = "layout: " + layout
+	return nil
+}
+
+// AddURLFlag adds an absolute URL flag to a FlagSet. Values must have
+// a scheme and, unless they are file or opaque URLs, a host.
+func (fs *FlagSet) AddURLFlag(key, shortName, usage string, defaultValue *url.URL) error {
+	v := defaultValue
+	_, err := fs.addVar(
+		URL,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&urlValue{p: &v},
+	)
+	return err
+}
+
+// AddRegexpFlag adds a regular expression flag to a FlagSet. Patterns
+// are compiled once, as for regexp.Compile, and malformed patterns are
+// parse errors.
+func (fs *FlagSet) AddRegexpFlag(key, shortName, usage string, defaultValue *regexp.Regexp) error {
+	v := defaultValue
+	_, err := fs.addVar(
+		REGEXP,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&regexpValue{p: &v},
+	)
+	return err
+}
+
+// SetURLSchemes restricts the schemes accepted by a URL flag, such as
+// "http" and "https". Usage lists the allowed schemes.
+func (fs *FlagSet) SetURLSchemes(key string, schemes ...string) error {
+	f, err := fs.flagDefined(key, URL)
+	if err != nil {
+		return err
+	}
+
+	if err := checkURL(f.defaultValue.(*url.URL), schemes); err != nil {
+		return fmt.Errorf("%q: invalid default: %v", key, err)
+	}
+	f.schemes = schemes
+	f.note = "schemes: " + strings.Join(schemes, ", ")
+	fs.coreFlagSet.Lookup(key).Value.(*urlValue).schemes = schemes
+	return nil
+}
+
+// AddFlag adds a base flag to a FlagSet
+func (fs *FlagSet) AddFlag(key, shortName, usage string) error {
+	_, err := fs.addFlag(
+		BASE,
+		key,
+		shortName,
+		usage,
+		nil,
+	)
+	return err
+}
+
+// AddIntFlag adds an integer flag to a FlagSet
+func (fs *FlagSet) AddIntFlag(key, shortName, usage string, defaultValue int64) error {
+	_, err := fs.addFlag(
+		INT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+	)
+	return err
+}
+
+// AddIntFlagRange adds an integer flag whose value must lie within
+// min and max inclusive. The default must itself be within the range.
+func (fs *FlagSet) AddIntFlagRange(key, shortName, usage string, defaultValue, min, max int64) error {
+	if min > max {
+		return fmt.Errorf("%q: invalid range [%d,%d]", key, min, max)
+	}
+	if defaultValue < min || defaultValue > max {
+		return fmt.Errorf("%q: default %d out of range [%d,%d]", key, defaultValue, min, max)
+	}
+
+	f, err := fs.addFlag(
+		INT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+	)
+	if err != nil {
+		return err
+	}
+	f.bounds = []int64{min, max}
+	return nil
+}
+
+// AddUintFlag adds an unsigned integer flag to a FlagSet
+func (fs *FlagSet) AddUintFlag(key, shortName, usage string, defaultValue uint64) error {
+	_, err := fs.addFlag(
+		UINT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+	)
+	return err
+}
+
+// AddIntFileFlag adds an integer flag whose value is either a literal
+// integer or "@path", naming a file whose content is the integer.
+// Missing files and non-numeric content are parse errors.
+func (fs *FlagSet) AddIntFileFlag(key, shortName, usage string, defaultValue int64) error {
+	v := defaultValue
+	f, err := fs.addVar(
+		INT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&intFileValue{p: &v},
+	)
+	if err != nil {
+		return err
+	}
+	f.note = "or @file to read the value from file"
+	return nil
+}
+
+// AddSignedIntFlag adds an integer flag for relative adjustments such
+// as "+5" or "-3". The sign is preserved; a negative value may follow
+// the flag as a separate argument ("--offset -3"), use the "=" form
+// ("--offset=-3"), and is never mistaken for a flag itself.
+func (fs *FlagSet) AddSignedIntFlag(key, shortName, usage string, defaultValue int64) error {
+	v := defaultValue
+	_, err := fs.addVar(
+		INT,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+		&v,
+		&signedIntValue{p: &v},
+	)
+	return err
+}
+
+// AddBoolFlag adds a boolean flag to a FlagSet
+func (fs *FlagSet) AddBoolFlag(key, shortName, usage string, defaultValue bool) error {
+	_, err := fs.addFlag(
+		BOOL,
+		key,
+		shortName,
+		usage,
+		defaultValue,
+	)
+	return err
+}
+
+// addFlag adds a new flag of one of the types built into the core
+// flag package to a FlagSet
+func (fs *FlagSet) addFlag(
+	flagType FlagType,
+	key, shortName, usage string,
+	defaultValue interface{}) (*Flag, error) {
+
+	return fs.addVar(flagType, key, shortName, usage, defaultValue, nil, nil)
+}
+
+// addVar adds a new flag to a FlagSet whose command line values are
+// parsed by v. value is the pointer that v writes through. If v is nil,
+// the value is created by the core flag package.
+func (fs *FlagSet) addVar(
+	flagType FlagType,
+	key, shortName, usage string,
+	defaultValue, value interface{},
+	v flag.Value) (*Flag, error) {
+
+	// Allow short names to be given as "-o" as well as "o"
+	shortName = strings.TrimPrefix(shortName, "-")
+
+	if err := validateName(key); err != nil {
+		return nil, err
+	}
+	if shortName != "" {
+		if err := validateName(shortName); err != nil {
+			return nil, err
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Names must be unique across keys and short names
+	if shortName == key {
+		return nil, fmt.Errorf("%q: short name is the same as the key", key)
+	}
+	for _, name := range []string{key, shortName} {
+		if other := fs.flagByName(name); name != "" && other != nil {
+			return nil, fmt.Errorf("%q: already defined by %s flag %q", name, other.flagType, other.key)
+		}
+	}
+
+	newFlag := new(Flag)
+	newFlag.key = key
+	newFlag.flagType = flagType
+	newFlag.shortName = shortName
+	newFlag.defaultValue = defaultValue
+	newFlag.usage = usage
+	newFlag.value = value
+
+	// Initialize values in core.flag
+	if v != nil {
+		fs.coreFlagSet.Var(v, key, usage)
+	} else {
+		switch flagType {
+		case BASE:
+			newFlag.value = fs.coreFlagSet.Bool(key, false, usage)
+		case BOOL:
+			newFlag.value = fs.coreFlagSet.Bool(key, defaultValue.(bool), usage)
+		case INT:
+			newFlag.value = fs.coreFlagSet.Int64(key, defaultValue.(int64), usage)
+		case FLOAT:
+			newFlag.value = fs.coreFlagSet.Float64(key, defaultValue.(float64), usage)
+		case STRING:
+			newFlag.value = fs.coreFlagSet.String(key, defaultValue.(string), usage)
+		case DURATION:
+			newFlag.value = fs.coreFlagSet.Duration(key, defaultValue.(time.Duration), usage)
+		case UINT:
+			newFlag.value = fs.coreFlagSet.Uint64(key, defaultValue.(uint64), usage)
+		}
+		v = fs.coreFlagSet.Lookup(key).Value
+	}
+
+	// The short name shares the value of the long name
+	if shortName != "" {
+		fs.coreFlagSet.Var(v, shortName, usage)
+	}
+
+	// Assign flag to FlagSet map
+	newFlag.order = len(fs.flag)
+	fs.flag[key] = newFlag
+
+	return newFlag, nil
+}
+
+// validateName checks a long or short flag name. Names may contain any
+// characters except whitespace and "=", and may not begin with "-".
+func validateName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("flag name is empty")
+	case strings.HasPrefix(name, "-"):
+		return fmt.Errorf("%q: flag name begins with \"-\"", name)
+	case strings.Contains(name, "="):
+		return fmt.Errorf("%q: flag name contains \"=\"", name)
+	case strings.IndexFunc(name, unicode.IsSpace) >= 0:
+		return fmt.Errorf("%q: flag name contains whitespace", name)
+	}
+
+	return nil
+}
+
+// get returns the current value of the flag, dereferenced according
+// to its type
+func (f *Flag) get() interface{} {
+	switch f.flagType {
+	case BASE, BOOL:
+		return *f.value.(*bool)
+	case INT, BYTES:
+		return *f.value.(*int64)
+	case DURATION:
+		return *f.value.(*time.Duration)
+	case UINT:
+		return *f.value.(*uint64)
+	case STRINGSLICE:
+		return append([]string(nil), *f.value.(*[]string)...)
+	case SORTSPEC:
+		return append([]SortField(nil), *f.value.(*[]SortField)...)
+	case INTSLICE:
+		return append([]int64(nil), *f.value.(*[]int64)...)
+	case COUNT:
+		return *f.value.(*int)
+	case IP:
+		return append(net.IP(nil), *f.value.(*net.IP)...)
+	case TIME:
+		return *f.value.(*time.Time)
+	case URL:
+		return *f.value.(**url.URL)
+	case REGEXP:
+		return *f.value.(**regexp.Regexp)
+	case FLOAT:
+		return *f.value.(*float64)
+	case STRING, SEMVER:
+		return *f.value.(*string)
+	case TYPEDMAP:
+		m := make(map[string]interface{}, len(*f.value.(*map[string]interface{})))
+		for k, v := range *f.value.(*map[string]interface{}) {
+			m[k] = v
+		}
+		return m
+	}
+	return nil
+}
+
+// set writes v through the value pointer of the flag. The dynamic
+// type of v must match the flag type.
+func (f *Flag) set(v interface{}) {
+	switch f.flagType {
+	case BASE, BOOL:
+		*f.value.(*bool) = v.(bool)
+	case INT, BYTES:
+		*f.value.(*int64) = v.(int64)
+	case DURATION:
+		*f.value.(*time.Duration) = v.(time.Duration)
+	case UINT:
+		*f.value.(*uint64) = v.(uint64)
+	case STRINGSLICE:
+		*f.value.(*[]string) = append([]string(nil), v.([]string)...)
+	case SORTSPEC:
+		*f.value.(*[]SortField) = append([]SortField(nil), v.([]SortField)...)
+	case INTSLICE:
+		*f.value.(*[]int64) = append([]int64(nil), v.([]int64)...)
+	case COUNT:
+		*f.value.(*int) = v.(int)
+	case IP:
+		*f.value.(*net.IP) = append(net.IP(nil), v.(net.IP)...)
+	case TIME:
+		*f.value.(*time.Time) = v.(time.Time)
+	case URL:
+		*f.value.(**url.URL) = v.(*url.URL)
+	case REGEXP:
+		*f.value.(**regexp.Regexp) = v.(*regexp.Regexp)
+	case FLOAT:
+		*f.value.(*float64) = v.(float64)
+	case STRING, SEMVER:
+		*f.value.(*string) = v.(string)
+	case TYPEDMAP:
+		*f.value.(*map[string]interface{}) = v.(map[string]interface{})
+	}
+}
+
+// isDefault reports whether the flag holds its declared default value
+func (f *Flag) isDefault() bool {
+	switch f.flagType {
+	case BASE:
+		return !*f.value.(*bool)
+	case TYPEDMAP:
+		return len(*f.value.(*map[string]interface{})) == 0
+	case SORTSPEC:
+		return len(*f.value.(*[]SortField)) == 0
+	case STRINGSLICE:
+		return equalStrings(*f.value.(*[]string), f.defaultValue.([]string))
+	case INTSLICE:
+		return formatInts(*f.value.(*[]int64)) == formatInts(f.defaultValue.([]int64))
+	case IP:
+		return f.value.(*net.IP).Equal(f.defaultValue.(net.IP))
+	case TIME:
+		return f.value.(*time.Time).Equal(f.defaultValue.(time.Time))
+	case URL:
+		return formatURL(*f.value.(**url.URL)) == formatURL(f.defaultValue.(*url.URL))
+	case REGEXP:
+		return formatRegexp(*f.value.(**regexp.Regexp)) == formatRegexp(f.defaultValue.(*regexp.Regexp))
+	}
+	return reflect.DeepEqual(f.get(), f.defaultValue)
+}
+
+// formatInts renders integers as a comma separated list
+func formatInts(v []int64) string {
+	s := make([]string, len(v))
+	for i, n := range v {
+		s[i] = strconv.FormatInt(n, 10)
+	}
+	return strings.Join(s, ",")
+}
+
+// equalStrings reports whether two string slices hold the same
+// elements, treating nil and empty as equal
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetToDefault returns the keys, in sorted order, of flags that were
+// set on the command line to a value equal to their default
+func (fs *FlagSet) SetToDefault() []string {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	var keys []string
+	for _, f := range sortFlags(fs.flag) {
+		if f.isSet && f.isDefault() {
+			keys = append(keys, f.key)
+		}
+	}
+
+	return keys
+}
+
+// format renders a value of the flag for display, using the flag's
+// display function if one is set
+func (f *Flag) format(v interface{}) string {
+	if f.display != nil {
+		return f.display(v)
+	}
+	return f.text(v)
+}
+
+// text renders a value of the flag as it is given on the command line
+func (f *Flag) text(v interface{}) string {
+	switch f.flagType {
+	case TYPEDMAP:
+		m := v.(map[string]interface{})
+		return (&typedMapValue{m: &m}).String()
+	case STRINGSLICE:
+		return strings.Join(v.([]string), ",")
+	case SORTSPEC:
+		p := v.([]SortField)
+		return (&sortSpecValue{p: &p}).String()
+	case INTSLICE:
+		return formatInts(v.([]int64))
+	case TIME:
+		return v.(time.Time).Format(f.layout)
+	case URL:
+		return formatURL(v.(*url.URL))
+	case REGEXP:
+		return formatRegexp(v.(*regexp.Regexp))
+	case IP:
+		if ip := v.(net.IP); len(ip) > 0 {
+			return ip.String()
+		}
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// ZeroValue returns the zero value for the type of a flag, with the
+// same dynamic type the typed getter returns
+func (fs *FlagSet) ZeroValue(key string) (interface{}, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	f, ok := fs.flag[key]
+	if !ok {
+		return nil, fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	return zeroValue(f.flagType), nil
+}
+
+// zeroValue returns the zero value backing a flag type
+func zeroValue(flagType FlagType) interface{} {
+	switch flagType {
+	case BASE, BOOL:
+		return false
+	case INT, BYTES:
+		return int64(0)
+	case FLOAT:
+		return float64(0)
+	case STRING, SEMVER:
+		return ""
+	case DURATION:
+		return time.Duration(0)
+	case UINT:
+		return uint64(0)
+	case STRINGSLICE:
+		return []string(nil)
+	case TYPEDMAP:
+		return map[string]interface{}(nil)
+	case SORTSPEC:
+		return []SortField(nil)
+	case INTSLICE:
+		return []int64(nil)
+	case COUNT:
+		return 0
+	case IP:
+		return net.IP(nil)
+	case TIME:
+		return time.Time{}
+	case URL:
+		return (*url.URL)(nil)
+	case REGEXP:
+		return (*regexp.Regexp)(nil)
+	}
+	return nil
+}
+
+// Lookup returns the flag stored under key and whether it exists
+func (fs *FlagSet) Lookup(key string) (*Flag, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	f, ok := fs.flag[key]
+	return f, ok
+}
+
+// VisitAll calls fn for each flag in lexicographical order of key
+func (fs *FlagSet) VisitAll(fn func(*Flag)) {
+	fs.mu.RLock()
+	flags := sortFlags(fs.flag)
+	fs.mu.RUnlock()
+
+	// fn may read flags itself
+	for _, f := range flags {
+		fn(f)
+	}
+}
+
+// Visit calls fn, in lexicographical order of key, for each flag that
+// was set on the command line by the last Parse
+func (fs *FlagSet) Visit(fn func(*Flag)) {
+	fs.mu.RLock()
+	var set []*Flag
+	for _, f := range sortFlags(fs.flag) {
+		if f.isSet {
+			set = append(set, f)
+		}
+	}
+	fs.mu.RUnlock()
+
+	// fn may read flags itself
+	for _, f := range set {
+		fn(f)
+	}
+}
+
+// WasSet reports whether the flag was given on the command line by the
+// last Parse, even if it was given its default value, as in
+// --verbose=false. It is false before Parse and for unknown keys.
+func (fs *FlagSet) WasSet(key string) bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	f, ok := fs.flag[fs.canonical(key)]
+	return ok && f.isSet
+}
+
+// GetArgs returns the arguments after flags
+func (fs *FlagSet) GetArgs() []string {
+	return fs.coreFlagSet.Args()
+}
+
+// SplitArgs partitions args into those naming flags of this FlagSet,
+// together with their values, and everything else. Arguments after a
+// "--" terminator are never claimed. This allows a host program to
+// parse its own flags and delegate the rest to another FlagSet.
+func (fs *FlagSet) SplitArgs(args []string) (mine []string, rest []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		name, _, hasValue, ok := parseFlagArg(arg)
+		if !ok || fs.coreFlagSet.Lookup(name) == nil {
+			rest = append(rest, arg)
+			continue
+		}
+
+		mine = append(mine, arg)
+		if !hasValue && !fs.isBoolName(name) && i+1 < len(args) {
+			i++
+			mine = append(mine, args[i])
+		}
+	}
+
+	return mine, rest
+}
+
+// parseFlagArg splits a command line argument of the form -name,
+// --name, -name=value or --name=value. ok is false if arg is not a flag.
+func parseFlagArg(arg string) (name, value string, hasValue, ok bool) {
+	if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+		return "", "", false, false
+	}
+
+	name = strings.TrimPrefix(arg[1:], "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name, value, hasValue = name[:i], name[i+1:], true
+	}
+	if name == "" {
+		return "", "", false, false
+	}
+
+	return name, value, hasValue, true
+}
+
+// isBoolName reports whether the flag registered under name takes no
+// value argument on the command line
+func (fs *FlagSet) isBoolName(name string) bool {
+	cf := fs.coreFlagSet.Lookup(name)
+	if cf == nil {
+		return false
+	}
+	bf, ok := cf.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// Get returns the value of any flag as type T, which must be the type
+// the flag's typed getter returns, e.g. Get[int64] for an INT flag.
+// A mismatched T is an error rather than a panic.
+func Get[T any](fs *FlagSet, key string) (T, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	var zero T
+
+	if !fs.isParsed {
+		return zero, fmt.Errorf("FlagSet %q has not been parsed", fs.name)
+	}
+	f, ok := fs.flag[key]
+	if !ok {
+		return zero, fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	v, ok := f.get().(T)
+	if !ok {
+		return zero, fmt.Errorf("%q: %s flag cannot be read as %v",
+			key, f.flagType, reflect.TypeOf(&zero).Elem())
+	}
+	return v, nil
+}
+
+// Get returns a basic flag value
+func (fs *FlagSet) Get(key string) (bool, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, BASE); err != nil {
+		return false, err
+	}
+
+	return *fs.flag[key].value.(*bool), nil
+}
+
+// GetBool returns a boolean flag value
+func (fs *FlagSet) GetBool(key string) (bool, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, BOOL); err != nil {
+		return false, err
+	}
+
+	return *fs.flag[key].value.(*bool), nil
+}
+
+// BoolState returns a boolean flag value and whether it was given
+// explicitly on the command line, distinguishing a value left at its
+// default from the same value set by the user
+func (fs *FlagSet) BoolState(key string) (value bool, explicit bool, err error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, BOOL); err != nil {
+		return false, false, err
+	}
+
+	f := fs.flag[key]
+	return *f.value.(*bool), f.isSet, nil
+}
+
+// GetInt returns an integer flag value
+func (fs *FlagSet) GetInt(key string) (int64, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, INT); err != nil {
+		return 0, err
+	}
+
+	return *fs.flag[key].value.(*int64), nil
+}
+
+// GetIntClamped returns an integer flag value clamped to [min,max].
+// Out of range values are clamped silently rather than reported.
+func (fs *FlagSet) GetIntClamped(key string, min, max int64) (int64, error) {
+	v, err := fs.GetInt(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if v < min {
+		return min, nil
+	}
+	if v > max {
+		return max, nil
+	}
+	return v, nil
+}
+
+// GetTypedMap returns a typed map flag value
+func (fs *FlagSet) GetTypedMap(key string) (map[string]interface{}, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, TYPEDMAP); err != nil {
+		return nil, err
+	}
+
+	return fs.flag[key].get().(map[string]interface{}), nil
+}
+
+// GetSemVer returns the components of a semantic version flag value
+func (fs *FlagSet) GetSemVer(key string) (major, minor, patch int, err error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err = fs.flagCheck(key, SEMVER); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return parseSemVer(*fs.flag[key].value.(*string))
+}
+
+// ViewStruct populates the fields of the struct pointed to by ptr from
+// the parsed flags. A field matches the flag named by its `flag` tag,
+// or otherwise the flag whose key equals the field name ignoring case.
+// Fields without a matching flag are left untouched. It is an error for
+// a flag value not to be assignable to its field.
+func (fs *FlagSet) ViewStruct(ptr interface{}) error {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if !fs.isParsed {
+		return fmt.Errorf("FlagSet %q has not been parsed", fs.name)
+	}
+
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ViewStruct requires a pointer to a struct, got %T", ptr)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		f := fs.structFlag(field)
+		if f == nil {
+			continue
+		}
+
+		v := reflect.ValueOf(f.get())
+		if !v.Type().AssignableTo(field.Type) {
+			return fmt.Errorf("%q: cannot assign %s flag to field %s of type %s",
+				f.key, typeName(f.flagType), field.Name, field.Type)
+		}
+		rv.Field(i).Set(v)
+	}
+
+	return nil
+}
+
+// structFlag returns the flag matching a struct field, or nil
+func (fs *FlagSet) structFlag(field reflect.StructField) *Flag {
+	if tag, ok := field.Tag.Lookup("flag"); ok {
+		return fs.flag[tag]
+	}
+
+	for k, f := range fs.flag {
+		if strings.EqualFold(k, field.Name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// GetAsString returns any flag value rendered as a string, using the
+// display function set by SetDisplayFunc if there is one
+func (fs *FlagSet) GetAsString(key string) (string, error) {
+	fs.mu.RLock()
+	key = fs.canonical(key)
+	if !fs.isParsed {
+		fs.mu.RUnlock()
+		return "", fmt.Errorf("FlagSet %q has not been parsed", fs.name)
+	}
+	f, ok := fs.flag[key]
+	if !ok {
+		fs.mu.RUnlock()
+		return "", fmt.Errorf("%q: flag does not exist", key)
+	}
+	v := f.get()
+	fs.mu.RUnlock()
+
+	// A display function may itself read flags
+	return f.format(v), nil
+}
+
+// PrecedenceChain returns the value each source provided for a flag,
+// from lowest to highest precedence. The last entry is the source of
+// the flag's current value.
+func (fs *FlagSet) PrecedenceChain(key string) ([]SourceValue, error) {
+	fs.mu.RLock()
+	key = fs.canonical(key)
+	if !fs.isParsed {
+		fs.mu.RUnlock()
+		return nil, fmt.Errorf("FlagSet %q has not been parsed", fs.name)
+	}
+	f, ok := fs.flag[key]
+	if !ok {
+		fs.mu.RUnlock()
+		return nil, fmt.Errorf("%q: flag does not exist", key)
+	}
+	layers := append([]layer(nil), f.layers...)
+	fs.mu.RUnlock()
+
+	// A display function may itself read flags
+	chain := make([]SourceValue, len(layers))
+	for i, l := range layers {
+		chain[i] = SourceValue{Source: l.source}
+		if l.verbatim {
+			chain[i].Value = l.value.(string)
+		} else {
+			chain[i].Value = f.format(l.value)
+		}
+	}
+	return chain, nil
+}
+
+// Source returns the source of a flag's current value, one of
+// SourceDefault, SourceConfig, SourceEnv or SourceCLI. A flag without
+// a default that no source set has an empty source.
+func (fs *FlagSet) Source(key string) (string, error) {
+	chain, err := fs.PrecedenceChain(key)
+	if err != nil || len(chain) == 0 {
+		return "", err
+	}
+	return chain[len(chain)-1].Source, nil
+}
+
+// SetDisplayFunc sets a function rendering the values of a flag for
+// GetAsString and the defaults shown by Usage. fn receives the value
+// with the same dynamic type the typed getter returns.
+func (fs *FlagSet) SetDisplayFunc(key string, fn func(value interface{}) string) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	f.display = fn
+	return nil
+}
+
+// GetBytes returns a byte count flag value
+func (fs *FlagSet) GetBytes(key string) (int64, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, BYTES); err != nil {
+		return 0, err
+	}
+
+	return *fs.flag[key].value.(*int64), nil
+}
+
+// GetDuration returns a duration flag value
+func (fs *FlagSet) GetDuration(key string) (time.Duration, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, DURATION); err != nil {
+		return 0, err
+	}
+
+	return *fs.flag[key].value.(*time.Duration), nil
+}
+
+// GetStringSlice returns a string slice flag value
+func (fs *FlagSet) GetStringSlice(key string) ([]string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, STRINGSLICE); err != nil {
+		return nil, err
+	}
+
+	return fs.flag[key].get().([]string), nil
+}
+
+// GetUint returns an unsigned integer flag value
+func (fs *FlagSet) GetUint(key string) (uint64, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, UINT); err != nil {
+		return 0, err
+	}
+
+	return *fs.flag[key].value.(*uint64), nil
+}
+
+// GetSortSpec returns a sort specification flag value
+func (fs *FlagSet) GetSortSpec(key string) ([]SortField, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, SORTSPEC); err != nil {
+		return nil, err
+	}
+
+	return fs.flag[key].get().([]SortField), nil
+}
+
+// GetIntSlice returns an integer slice flag value
+func (fs *FlagSet) GetIntSlice(key string) ([]int64, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, INTSLICE); err != nil {
+		return nil, err
+	}
+
+	return fs.flag[key].get().([]int64), nil
+}
+
+// GetCount returns the number of times a count flag was given
+func (fs *FlagSet) GetCount(key string) (int, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, COUNT); err != nil {
+		return 0, err
+	}
+
+	return *fs.flag[key].value.(*int), nil
+}
+
+// GetIP returns an IP address flag value
+func (fs *FlagSet) GetIP(key string) (net.IP, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, IP); err != nil {
+		return nil, err
+	}
+
+	return fs.flag[key].get().(net.IP), nil
+}
+
+// GetTime returns a timestamp flag value
+func (fs *FlagSet) GetTime(key string) (time.Time, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, TIME); err != nil {
+		return time.Time{}, err
+	}
+
+	return *fs.flag[key].value.(*time.Time), nil
+}
+
+// GetURL returns a URL flag value, which is nil when the flag has no
+// default and was not given
+func (fs *FlagSet) GetURL(key string) (*url.URL, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, URL); err != nil {
+		return nil, err
+	}
+
+	return *fs.flag[key].value.(**url.URL), nil
+}
+
+// GetRegexp returns a regular expression flag value, which is nil when
+// the flag has no default and was not given
+func (fs *FlagSet) GetRegexp(key string) (*regexp.Regexp, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, REGEXP); err != nil {
+		return nil, err
+	}
+
+	return *fs.flag[key].value.(**regexp.Regexp), nil
+}
+
+// GetFloat returns a float flag value
+func (fs *FlagSet) GetFloat(key string) (float64, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, FLOAT); err != nil {
+		return 0.00, err
+	}
+
+	return *fs.flag[key].value.(*float64), nil
+}
+
+// GetString returns a string flag value
+func (fs *FlagSet) GetString(key string) (string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	key = fs.canonical(key)
+
+	if err := fs.flagCheck(key, STRING); err != nil {
+		return "", err
+	}
+
+	return *fs.flag[key].value.(*string), nil
+}
+
+// GetPercent returns a percentage flag value from 0 to 100
+func (fs *FlagSet) GetPercent(key string) (float64, error) {
+	return fs.GetFloat(key)
+}
+
+// GetFilePath returns a file path flag value
+func (fs *FlagSet) GetFilePath(key string) (string, error) {
+	return fs.GetString(key)
+}
+
+// MustGet is like Get but panics if the flag cannot be read. It is
+// intended for programs where a missing or mistyped flag is a
+// programming error, not a user error.
+func (fs *FlagSet) MustGet(key string) bool {
+	v, err := fs.Get(key)
+	if err != nil {
+		panic(fmt.Sprintf("flagplus: MustGet(%q): %v", key, err))
+	}
+	return v
+}
+
+// MustGetBool is like GetBool but panics if the flag cannot be read.
+// See MustGet.
+func (fs *FlagSet) MustGetBool(key string) bool {
+	v, err := fs.GetBool(key)
+	if err != nil {
+		panic(fmt.Sprintf("flagplus: MustGetBool(%q): %v", key, err))
+	}
+	return v
+}
+
+// MustGetInt is like GetInt but panics if the flag cannot be read.
+// See MustGet.
+func (fs *FlagSet) MustGetInt(key string) int64 {
+	v, err := fs.GetInt(key)
+	if err != nil {
+		panic(fmt.Sprintf("flagplus: MustGetInt(%q): %v", key, err))
+	}
+	return v
+}
+
+// MustGetFloat is like GetFloat but panics if the flag cannot be read.
+// See MustGet.
+func (fs *FlagSet) MustGetFloat(key string) float64 {
+	v, err := fs.GetFloat(key)
+	if err != nil {
+		panic(fmt.Sprintf("flagplus: MustGetFloat(%q): %v", key, err))
+	}
+	return v
+}
+
+// MustGetString is like GetString but panics if the flag cannot be
+// read. See MustGet.
+func (fs *FlagSet) MustGetString(key string) string {
+	v, err := fs.GetString(key)
+	if err != nil {
+		panic(fmt.Sprintf("flagplus: MustGetString(%q): %v", key, err))
+	}
+	return v
+}
+
+// flagCheck inspects the flag map by key for presence, type and
+// if being requested prior to parse
+func (fs *FlagSet) flagCheck(key string, flagType FlagType) error {
+	// Has the flag set been parsed?
+	if !fs.isParsed {
+		return fmt.Errorf("FlagSet %q has not been parsed", fs.name)
+	}
+
+	_, err := fs.flagDefined(key, flagType)
+	return err
+}
+
+// flagDefined inspects the flag map by key for presence and type,
+// returning the flag. It may be used before parse.
+func (fs *FlagSet) flagDefined(key string, flagType FlagType) (*Flag, error) {
+	// Check if key exists
+	f, ok := fs.flag[key]
+	if !ok {
+		return nil, fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	// Check if the flag type matches expectation
+	if f.flagType != flagType {
+		return nil, fmt.Errorf("%q: incorrect flag type", key)
+	}
+
+	return f, nil
+}
+
+// SetRequired marks a flag as mandatory. Parse returns an error listing
+// every required flag that was not given on the command line, unless
+// the flag has a non-zero default, which satisfies the requirement.
+func (fs *FlagSet) SetRequired(key string) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	f.required = true
+	return nil
+}
+
+// SetValidator sets a function checking the value of a flag once it
+// is parsed, after the built-in checks. fn receives the value with the
+// same dynamic type the typed getter returns; an error it returns is
+// returned by Parse. Validators run in sorted order of key.
+func (fs *FlagSet) SetValidator(key string, fn func(value interface{}) error) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	f.validator = fn
+	return nil
+}
+
+// MarkRequires declares that when the flag key is given, on the command
+// line or through the environment, each of requiredKeys must be given
+// too. Parse reports the missing dependencies.
+func (fs *FlagSet) MarkRequires(key string, requiredKeys ...string) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+	for _, k := range requiredKeys {
+		if _, ok := fs.flag[k]; !ok {
+			return fmt.Errorf("%q: flag does not exist", k)
+		}
+	}
+
+	f.requires = append(f.requires, requiredKeys...)
+	return nil
+}
+
+// hasDefault reports whether the flag has a non-zero default value
+func (f *Flag) hasDefault() bool {
+	switch f.flagType {
+	case BASE, TYPEDMAP, SORTSPEC:
+		return false
+	case STRINGSLICE:
+		return len(f.defaultValue.([]string)) > 0
+	case INTSLICE:
+		return len(f.defaultValue.([]int64)) > 0
+	case IP:
+		return len(f.defaultValue.(net.IP)) > 0
+	case TIME:
+		return !f.defaultValue.(time.Time).IsZero()
+	case URL:
+		return f.defaultValue.(*url.URL) != nil
+	case REGEXP:
+		return f.defaultValue.(*regexp.Regexp) != nil
+	}
+	return f.defaultValue != zeroValue(f.flagType)
+}
+
+// SetNonEmpty marks a string flag so that explicitly setting it to an
+// empty string, as in "--output=", is a parse error. Leaving the flag
+// unset is not affected.
+func (fs *FlagSet) SetNonEmpty(key string) error {
+	f, err := fs.flagDefined(key, STRING)
+	if err != nil {
+		return err
+	}
+
+	f.nonEmpty = true
+	return nil
+}
+
+// AddExample adds an example invocation to the "Examples:" section of
+// Usage. Examples are shown in the order added.
+func (fs *FlagSet) AddExample(description, commandLine string) {
+	fs.examples = append(fs.examples, example{description, commandLine})
+}
+
+// FlagSetDescription sets the optional description of the FlagSet
+func (fs *FlagSet) FlagSetDescription(description string) {
+	fs.description = description
+}
+
+// Set changes the value of a flag, typically after Parse. value must
+// have the same dynamic type the flag's typed getter returns, e.g.
+// int64 for an INT flag; unlike SimulateArg it is not parsed from a
+// string.
+func (fs *FlagSet) Set(key string, value interface{}) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	key = fs.canonical(key)
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	want := reflect.TypeOf(zeroValue(f.flagType))
+	if reflect.TypeOf(value) != want {
+		return fmt.Errorf("%q: cannot set %s flag to %T, want %v", key, f.flagType, value, want)
+	}
+	f.set(value)
+	return nil
+}
+
+// SimulateArg allows the test suite to simulate command-line arguments
+func (fs *FlagSet) SimulateArg(name string, value string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.coreFlagSet.Set(name, value); err != nil {
+		return err
+	}
+	fs.simulated = append(fs.simulated, name)
+	return nil
+}
+
+// Parse parses flag definitions from args, or from os.Args if no args
+// are given. The first argument is the program name and is skipped;
+// os.Args itself is never modified.
+//
+// Every flag may be given by its long or short name with one or two
+// leading dashes. A value may be joined with "=" for all flag types:
+//
+//	--key=value  -k=value
+//
+// or, for flags other than BASE and BOOL, given as the next argument:
+//
+//	--key value  -k value
+//
+// BASE and BOOL flags are set to true by their name alone, "--key";
+// "--key=false" turns them off. A following "true" or "false" argument
+// is not consumed and is left as an argument after flags.
+//
+// Parsing stops at the first argument that is not a flag or at a "--"
+// terminator. The terminator itself is dropped, and every argument
+// after it, even one beginning with a dash, is left verbatim to
+// GetArgs:
+//
+//	util -v -- -weirdfile  // GetArgs() is ["-weirdfile"]
+//
+// What Parse does on error depends on SetErrorHandling; by default it
+// returns the error.
+func (fs *FlagSet) Parse(args ...string) error {
+	err := fs.parse(args)
+	if err == nil {
+		return nil
+	}
+
+	switch fs.errorHandling {
+	case flag.ExitOnError:
+		if errors.Is(err, ErrHelp) || errors.Is(err, ErrVersion) {
+			os.Exit(0)
+		}
+		// The core flag package has already reported its own errors
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			fmt.Fprintln(fs.Output(), err)
+			fs.printUsage()
+		}
+		os.Exit(2)
+	case flag.PanicOnError:
+		panic(err)
+	}
+	return err
+}
+
+// Parsed reports whether Parse has been called
+func (fs *FlagSet) Parsed() bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	return fs.isParsed
+}
+
+// ParseArgs parses flag definitions from args, all of which are flag
+// arguments; unlike Parse there is no leading program name. It is
+// otherwise the same as Parse.
+func (fs *FlagSet) ParseArgs(args []string) error {
+	return fs.Parse(append([]string{fs.name}, args...)...)
+}
+
+// parse implements Parse, returning any error. Flag values are locked
+// against concurrent reads while they are set, but not while user code
+// such as choices functions and the post-parse hook runs, since it may
+// read them itself.
+func (fs *FlagSet) parse(args []string) error {
+	fs.mu.Lock()
+	prev, err := fs.parseLocked(args)
+	fs.mu.Unlock()
+
+	switch {
+	case err == ErrHelp:
+		fs.printUsage()
+		return err
+	case err == ErrVersion:
+		fmt.Fprintln(fs.Output(), fs.version)
+		return err
+	case err != nil:
+		return err
+	}
+
+	err = fs.checkCallbacks()
+	if err == nil && fs.postParse != nil {
+		err = fs.postParse(fs)
+	}
+	if err != nil {
+		fs.mu.Lock()
+		fs.restoreState(prev)
+		fs.mu.Unlock()
+	}
+	return err
+}
+
+// parseState is the state of a FlagSet before a Parse, restored if
+// the Parse fails
+type parseState struct {
+	parsed    bool
+	flags     map[string]flagState
+	posValues map[string][]string
+}
+
+// flagState is the parse state of a single flag
+type flagState struct {
+	value         interface{}
+	isSet, envSet bool
+	layers        []layer
+}
+
+// saveState returns the parse state of the FlagSet
+func (fs *FlagSet) saveState() *parseState {
+	state := &parseState{
+		parsed:    fs.isParsed,
+		flags:     make(map[string]flagState, len(fs.flag)),
+		posValues: fs.posValues,
+	}
+	for key, f := range fs.flag {
+		state.flags[key] = flagState{f.get(), f.isSet, f.envSet, f.layers}
+	}
+	return state
+}
+
+// restoreState returns the FlagSet to a saved parse state, so that a
+// failed Parse leaves no trace
+func (fs *FlagSet) restoreState(state *parseState) {
+	fs.resetCore()
+	for key, f := range fs.flag {
+		saved := state.flags[key]
+		f.set(saved.value)
+		f.isSet = saved.isSet
+		f.envSet = saved.envSet
+		f.layers = saved.layers
+	}
+	fs.posValues = state.posValues
+	fs.isParsed = state.parsed
+}
+
+// parseLocked parses args into the flags with the lock held. The FlagSet
+// is only marked parsed if every check passes; otherwise it is restored
+// to the returned state from before the Parse.
+func (fs *FlagSet) parseLocked(args []string) (*parseState, error) {
+	if fs.isParsed && !fs.allowReparse {
+		return nil, fmt.Errorf("FlagSet %q has already been parsed; call Reset to parse again", fs.name)
+	}
+
+	prev := fs.saveState()
+	err := fs.parseArgs(args)
+	switch err {
+	case nil, ErrHelp, ErrVersion:
+		fs.isParsed = true
+		fs.simulated = nil
+	default:
+		fs.restoreState(prev)
+	}
+	return prev, err
+}
+
+// parseArgs sets the flags from args and checks them
+func (fs *FlagSet) parseArgs(args []string) error {
+	// A reparse keeps the values of the last but none of what set them
+	fs.resetCore()
+	for _, f := range fs.flag {
+		if r, ok := fs.coreFlagSet.Lookup(f.key).Value.(resetter); ok {
+			r.reset()
+		}
+		f.isSet = false
+		f.envSet = false
+		f.layers = nil
+	}
+
+	// Explicit args, like os.Args, begin with the program name
+	if len(args) == 0 {
+		args = os.Args
+	}
+	rest := args[1:]
+	if len(rest) == 0 && fs.defaultArgs != nil {
+		rest = fs.defaultArgs
+	}
+	normalized, err := fs.normalizeArgs(rest)
+	if err != nil {
+		return err
+	}
+	err = fs.coreFlagSet.Parse(normalized)
+	if err != nil {
+		return &ParseError{FlagSet: fs.name, Flag: errorFlagName(err), Err: err}
+	}
+
+	// Record which flags were set on the command line
+	fs.coreFlagSet.Visit(func(cf *flag.Flag) {
+		if f := fs.flagByName(cf.Name); f != nil {
+			f.isSet = true
+		}
+	})
+	for _, name := range fs.simulated {
+		if f := fs.flagByName(name); f != nil {
+			f.isSet = true
+		}
+	}
+
+	for _, f := range fs.flag {
+		f.layers = nil
+		switch {
+		case f.loaded:
+			if f.baseDefault != nil {
+				f.layers = append(f.layers, layer{source: SourceDefault, value: f.baseDefault})
+			}
+			f.layers = append(f.layers, layer{source: SourceConfig, value: f.defaultValue})
+		case f.defaultValue != nil:
+			f.layers = append(f.layers, layer{source: SourceDefault, value: f.defaultValue})
+		}
+	}
+
+	// Help stops parsing before any validation can fail; parse writes
+	// the usage or version once unlocked
+	if fs.helpEnabled && fs.flag["help"].isSet {
+		return ErrHelp
+	}
+	if fs.version != "" && fs.flag["version"].isSet {
+		return ErrVersion
+	}
+
+	if err := fs.applyEnv(); err != nil {
+		return err
+	}
+
+	// The command line outranks the environment
+	for _, f := range fs.flag {
+		if f.isSet {
+			f.layers = append(f.layers, layer{source: SourceCLI, value: f.get()})
+		}
+	}
+
+	if err := fs.validate(); err != nil {
+		return err
+	}
+	if err := fs.checkArgRange(); err != nil {
+		return err
+	}
+	return fs.bindPositionals()
+}
+
+// SetEnvPrefix sets the prefix of environment variable names derived
+// from flag keys by BindEnv, e.g. prefix "APP" maps "output" to
+// APP_OUTPUT
+func (fs *FlagSet) SetEnvPrefix(prefix string) {
+	fs.envPrefix = prefix
+}
+
+// BindEnv binds a flag to an environment variable. If envVar is empty,
+// the name is derived from the env prefix and key. When the flag is
+// not given on the command line, Parse applies the variable's value if
+// it is set; command line values always win, and env values win over
+// defaults.
+func (fs *FlagSet) BindEnv(key, envVar string) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	f.envBound = true
+	f.envVar = envVar
+	return nil
+}
+
+// envName returns the environment variable bound to a flag
+func (fs *FlagSet) envName(f *Flag) string {
+	if f.envVar != "" {
+		return f.envVar
+	}
+
+	name := strings.ToUpper(f.key)
+	name = strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	if fs.envPrefix != "" {
+		name = fs.envPrefix + "_" + name
+	}
+	return name
+}
+
+// applyEnv sets flags not given on the command line from their bound
+// environment variables
+func (fs *FlagSet) applyEnv() error {
+	for _, f := range sortFlags(fs.flag) {
+		if !f.envBound {
+			continue
+		}
+
+		name := fs.envName(f)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		f.layers = append(f.layers, layer{source: SourceEnv, value: value, verbatim: true})
+		if f.isSet {
+			continue
+		}
+		if err := fs.coreFlagSet.Set(f.key, value); err != nil {
+			return fmt.Errorf("%q: invalid value %q in environment variable %s: %v",
+				f.key, value, name, err)
+		}
+		f.envSet = true
+	}
+
+	return nil
+}
+
+// SetErrorHandling sets what Parse does on error, as for the core flag
+// package. With flag.ContinueOnError, the default, Parse returns the
+// error. With flag.ExitOnError it writes the error and Usage to the
+// output and exits with status 2, or 0 for ErrHelp and ErrVersion.
+// With flag.PanicOnError it panics with the error.
+func (fs *FlagSet) SetErrorHandling(h flag.ErrorHandling) {
+	fs.errorHandling = h
+}
+
+// SetOutput sets the destination for usage and error messages. If w
+// is nil, os.Stderr is used.
+func (fs *FlagSet) SetOutput(w io.Writer) {
+	fs.output = w
+	fs.coreFlagSet.SetOutput(w)
+}
+
+// Output returns the destination for usage and error messages
+func (fs *FlagSet) Output() io.Writer {
+	if fs.output == nil {
+		return os.Stderr
+	}
+	return fs.output
+}
+
+// WithOutput sets the output to w while fn runs, restoring the previous
+// output afterwards, even if fn panics
+func (fs *FlagSet) WithOutput(w io.Writer, fn func()) {
+	prev := fs.output
+	fs.SetOutput(w)
+	defer fs.SetOutput(prev)
+
+	fn()
+}
+
+// SetAllowReparse controls whether Parse may be called again on a
+// FlagSet that has already been parsed. By default a second Parse is
+// an error, as it is usually a bug. A reparse starts from the values of
+// the last, but only the flags it sets itself count as set. A Parse
+// that fails does not count.
+func (fs *FlagSet) SetAllowReparse(allow bool) {
+	fs.allowReparse = allow
+}
+
+// EnableHelp adds a --help flag, with short name -h unless that is
+// taken, that makes Parse write Usage to the output and return ErrHelp
+func (fs *FlagSet) EnableHelp() error {
+	if _, ok := fs.flag["help"]; ok {
+		return fmt.Errorf("%q: flag already exists", "help")
+	}
+
+	shortName := "h"
+	if fs.coreFlagSet.Lookup(shortName) != nil {
+		shortName = ""
+	}
+	if err := fs.AddFlag("help", shortName, "Show this help"); err != nil {
+		return err
+	}
+	fs.helpEnabled = true
+	return nil
+}
+
+// EnableVersion adds a --version flag, with short name -V unless that
+// is taken, that makes Parse write version to the output and return
+// ErrVersion
+func (fs *FlagSet) EnableVersion(version string) error {
+	if version == "" {
+		return fmt.Errorf("version is empty")
+	}
+	if _, ok := fs.flag["version"]; ok {
+		return fmt.Errorf("%q: flag already exists", "version")
+	}
+
+	shortName := "V"
+	if fs.coreFlagSet.Lookup(shortName) != nil {
+		shortName = ""
+	}
+	if err := fs.AddFlag("version", shortName, "Show the version"); err != nil {
+		return err
+	}
+	fs.version = version
+	return nil
+}
+
+// MarkHidden leaves a flag out of Usage, for internal or debugging
+// flags that should not be advertised. The flag still works as usual.
+func (fs *FlagSet) MarkHidden(key string) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	f.hidden = true
+	return nil
+}
+
+// SetDefaultArgs sets arguments Parse uses in place of an empty
+// command line, so that running without arguments performs a common
+// action. Any explicit argument disables them entirely.
+func (fs *FlagSet) SetDefaultArgs(args ...string) {
+	fs.defaultArgs = append([]string{}, args...)
+}
+
+// Reset returns every flag to its default and clears the parsed state,
+// so that the FlagSet, and the FlagSets of its subcommands, can parse
+// a new command line as if newly built. Flag definitions and settings
+// are kept.
+func (fs *FlagSet) Reset() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.resetCore()
+	for _, f := range fs.flag {
+		if f.defaultValue != nil {
+			f.set(f.defaultValue)
+		} else {
+			f.set(zeroValue(f.flagType))
+		}
+		if r, ok := fs.coreFlagSet.Lookup(f.key).Value.(resetter); ok {
+			r.reset()
+		}
+		f.isSet = false
+		f.envSet = false
+		f.layers = nil
+	}
+
+	for _, sub := range fs.subcommands {
+		sub.Reset()
+	}
+	fs.activeSub = ""
+	fs.posValues = nil
+	fs.isParsed = false
+}
+
+// resetCore re-registers the existing values on a fresh core FlagSet,
+// which forgets the flags set by an earlier parse
+func (fs *FlagSet) resetCore() {
+	type coreFlag struct {
+		name, usage string
+		value       flag.Value
+	}
+	var core []coreFlag
+	fs.coreFlagSet.VisitAll(func(cf *flag.Flag) {
+		core = append(core, coreFlag{cf.Name, cf.Usage, cf.Value})
+	})
+	fs.coreFlagSet = flag.FlagSet{Usage: fs.coreFlagSet.Usage}
+	if fs.output != nil {
+		fs.coreFlagSet.SetOutput(fs.output)
+	}
+	for _, cf := range core {
+		fs.coreFlagSet.Var(cf.value, cf.name, cf.usage)
+	}
+}
+
+// SetPostParse sets a hook run once Parse has succeeded, including all
+// validation. An error returned by the hook is returned by Parse.
+func (fs *FlagSet) SetPostParse(fn func(fs *FlagSet) error) {
+	fs.postParse = fn
+}
+
+// validate checks the parsed flag values against their constraints
+func (fs *FlagSet) validate() error {
+	// Report every missing required flag at once
+	var missing []string
+	for _, f := range sortFlags(fs.flag) {
+		if f.required && !f.isSet && !f.envSet && !f.hasDefault() {
+			missing = append(missing, fmt.Sprintf("%q", f.key))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required flags: %s", strings.Join(missing, ", "))
+	}
+
+	// Dependencies only apply to flags that were actually given
+	for _, f := range sortFlags(fs.flag) {
+		if !f.isSet && !f.envSet {
+			continue
+		}
+		var absent []string
+		for _, k := range f.requires {
+			if dep := fs.flag[k]; !dep.isSet && !dep.envSet {
+				absent = append(absent, fmt.Sprintf("%q", k))
+			}
+		}
+		if len(absent) > 0 {
+			return fmt.Errorf("%q: must be used with %s", f.key, strings.Join(absent, ", "))
+		}
+	}
+
+	for _, f := range sortFlags(fs.flag) {
+		if f.nonEmpty && f.isSet && *f.value.(*string) == "" {
+			return fmt.Errorf("%q: value must not be empty", f.key)
+		}
+		if f.bounds != nil {
+			v := *f.value.(*int64)
+			if v < f.bounds[0] || v > f.bounds[1] {
+				return fmt.Errorf("%q: value %d out of range [%d,%d]",
+					f.key, v, f.bounds[0], f.bounds[1])
+			}
+		}
+	}
+
+	return nil
+}
+
+// Lint returns every problem found in the definition of the FlagSet
+// itself, such as flags without usage or defaults that their own
+// constraints reject. A well-formed FlagSet returns nil.
+func (fs *FlagSet) Lint() []string {
+	var problems []string
+	names := make(map[string]string, 2*len(fs.flag))
+	for _, f := range sortFlags(fs.flag) {
+		names[f.key] = f.key
+	}
+
+	for _, f := range sortFlags(fs.flag) {
+		if strings.TrimSpace(f.usage) == "" {
+			problems = append(problems, fmt.Sprintf("%q: missing usage", f.key))
+		}
+
+		if f.shortName != "" {
+			if other, ok := names[f.shortName]; ok && other != f.key {
+				problems = append(problems, fmt.Sprintf("%q: short name %q collides with %q",
+					f.key, f.shortName, other))
+			} else {
+				names[f.shortName] = f.key
+			}
+		}
+
+		if f.required && f.hasDefault() {
+			problems = append(problems, fmt.Sprintf("%q: required flag has default %s",
+				f.key, f.format(f.defaultValue)))
+		}
+
+		if f.choicesFn != nil && f.defaultValue.(string) != "" {
+			def, choices := f.defaultValue.(string), f.choicesFn()
+			found := false
+			for _, c := range choices {
+				if c == def {
+					found = true
+					break
+				}
+			}
+			if !found {
+				problems = append(problems, fmt.Sprintf("%q: default %q is not one of [%s]",
+					f.key, def, strings.Join(choices, "|")))
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkCallbacks runs the checks that call user code, choices
+// functions and validators, without the lock held so that they may
+// read flags, as a rule spanning several flags does
+func (fs *FlagSet) checkCallbacks() error {
+	fs.mu.RLock()
+	flags := sortFlags(fs.flag)
+	fs.mu.RUnlock()
+
+	for _, f := range flags {
+		if f.choicesFn != nil {
+			if err := fs.checkChoice(f); err != nil {
+				return err
+			}
+		}
+		if f.validator != nil {
+			fs.mu.RLock()
+			v := f.get()
+			fs.mu.RUnlock()
+			if err := f.validator(v); err != nil {
+				return fmt.Errorf("%q: %v", f.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkChoice verifies a string flag holds one of its permitted values.
+// An empty value left at its default is not checked.
+func (fs *FlagSet) checkChoice(f *Flag) error {
+	fs.mu.RLock()
+	v, isSet := *f.value.(*string), f.isSet
+	fs.mu.RUnlock()
+	if v == "" && !isSet {
+		return nil
+	}
+
+	choices := f.choicesFn()
+	for _, c := range choices {
+		if v == c {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%q: invalid value %q, must be one of [%s]",
+		f.key, v, strings.Join(choices, "|"))
+}
+
+// Values returns the current value of every flag by key, each with the
+// same dynamic type its typed getter returns; BASE and BOOL flags are
+// both bools. Before Parse it returns an empty map.
+func (fs *FlagSet) Values() map[string]interface{} {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	values := make(map[string]interface{}, len(fs.flag))
+	if !fs.isParsed {
+		return values
+	}
+	for k, f := range fs.flag {
+		values[k] = f.get()
+	}
+	return values
+}
+
+// Snapshot captures the current value and set-state of every flag
+func (fs *FlagSet) Snapshot() *StateSnapshot {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	s := &StateSnapshot{
+		owner: fs,
+		value: make(map[string]interface{}, len(fs.flag)),
+		isSet: make(map[string]bool, len(fs.flag)),
+	}
+	for k, f := range fs.flag {
+		s.value[k] = f.get()
+		s.isSet[k] = f.isSet
+	}
+
+	return s
+}
+
+// RestoreSnapshot writes the values and set-state held by a snapshot
+// back into the flags. The snapshot must have been taken from fs.
+func (fs *FlagSet) RestoreSnapshot(s *StateSnapshot) error {
+	if s == nil {
+		return fmt.Errorf("FlagSet %q: nil snapshot", fs.name)
+	}
+	if s.owner != fs {
+		return fmt.Errorf("FlagSet %q: snapshot taken from a different FlagSet", fs.name)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Check every flag before changing any
+	for _, f := range sortFlags(fs.flag) {
+		if _, ok := s.value[f.key]; !ok {
+			return fmt.Errorf("%q: flag not in snapshot", f.key)
+		}
+	}
+	for k, f := range fs.flag {
+		f.set(s.value[k])
+		f.isSet = s.isSet[k]
+	}
+
+	return nil
+}
+
+// InheritValues applies the current values of flags in base as the
+// defaults of the matching flags in fs. Flags already set on the command
+// line keep their values; flags in only one of the sets are ignored.
+// Matching flags must have the same type.
+func (fs *FlagSet) InheritValues(base *FlagSet) error {
+	// Read base before locking fs, which may be the same FlagSet
+	base.mu.RLock()
+	values := make(map[string]interface{}, len(base.flag))
+	types := make(map[string]FlagType, len(base.flag))
+	for k, bf := range base.flag {
+		values[k] = bf.get()
+		types[k] = bf.flagType
+	}
+	base.mu.RUnlock()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, f := range sortFlags(fs.flag) {
+		v, ok := values[f.key]
+		if !ok {
+			continue
+		}
+		if types[f.key] != f.flagType {
+			return fmt.Errorf("%q: incorrect flag type", f.key)
+		}
+
+		if f.flagType != BASE {
+			f.defaultValue = v
+		}
+		if !f.isSet {
+			f.set(v)
+		}
+	}
+
+	return nil
+}
+
+// flagByName returns the flag registered under a long or short name,
+// or nil if there is none. Long names match in any case when names are
+// case insensitive.
+func (fs *FlagSet) flagByName(name string) *Flag {
+	if f, ok := fs.flag[name]; ok {
+		return f
+	}
+	for _, f := range fs.flag {
+		if f.shortName == name {
+			return f
+		}
+		for _, alias := range f.aliases {
+			if alias == name {
+				return f
+			}
+		}
+	}
+
+	// Long names may differ in case
+	if fs.caseFold && len(name) > 1 {
+		for _, f := range sortFlags(fs.flag) {
+			for _, long := range append([]string{f.key}, f.aliases...) {
+				if strings.EqualFold(long, name) {
+					return f
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func unquoteUsage(flag *Flag) (name string, usage string) {
+	usage = flag.usage
 
 	for i := 0; i < len(usage); i++ {
 		if usage[i] == '`' {
@@ -298,17 +2842,50 @@ func unquoteUsage(flag *Flag) (name string, usage string) {
 	}
 
 	// If not explicit in usage `backquotes`, use type
-	switch flag.flagType {
+	name = typeName(flag.flagType)
+	if flag.placeholder != "" {
+		name = flag.placeholder
+	}
+	return
+}
+
+// typeName returns the name of a flag type as shown in usage
+func typeName(flagType FlagType) string {
+	switch flagType {
 	case BOOL:
-		name = "bool"
+		return "bool"
 	case INT:
-		name = "int"
+		return "int"
 	case FLOAT:
-		name = "float"
+		return "float"
 	case STRING:
-		name = "string"
+		return "string"
+	case TYPEDMAP:
+		return "map"
+	case SEMVER:
+		return "version"
+	case BYTES:
+		return "size"
+	case DURATION:
+		return "duration"
+	case STRINGSLICE:
+		return "list"
+	case UINT:
+		return "uint"
+	case SORTSPEC:
+		return "fields"
+	case INTSLICE:
+		return "ints"
+	case IP:
+		return "ip"
+	case TIME:
+		return "time"
+	case URL:
+		return "url"
+	case REGEXP:
+		return "regexp"
 	}
-	return
+	return ""
 }
 
 // sortFlags returns the flags as a slice in lexicographical sorted order.
@@ -331,15 +2908,35 @@ func flagDefaultValue(flag *Flag) string {
 	s := ""
 
 	switch flag.flagType {
-	case BOOL:
-		s = fmt.Sprintf(" (default=%v)", flag.defaultValue.(bool))
-	case INT:
-		s = fmt.Sprintf(" (default=%v)", flag.defaultValue.(int64))
-	case FLOAT:
-		s = fmt.Sprintf(" (default=%v)", flag.defaultValue.(float64))
-	case STRING:
+	case BOOL, INT, FLOAT, BYTES, DURATION, UINT:
+		s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
+	case STRING, SEMVER:
 		if flag.defaultValue.(string) != "" {
-			s = fmt.Sprintf(" (default=%v)", flag.defaultValue.(string))
+			s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
+		}
+	case STRINGSLICE:
+		if len(flag.defaultValue.([]string)) > 0 {
+			s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
+		}
+	case INTSLICE:
+		if len(flag.defaultValue.([]int64)) > 0 {
+			s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
+		}
+	case IP:
+		if len(flag.defaultValue.(net.IP)) > 0 {
+			s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
+		}
+	case TIME:
+		if !flag.defaultValue.(time.Time).IsZero() {
+			s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
+		}
+	case URL:
+		if flag.defaultValue.(*url.URL) != nil {
+			s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
+		}
+	case REGEXP:
+		if flag.defaultValue.(*regexp.Regexp) != nil {
+			s = fmt.Sprintf(" (default=%s)", flag.format(flag.defaultValue))
 		}
 	}
 
@@ -347,22 +2944,342 @@ func flagDefaultValue(flag *Flag) string {
 }
 
 // flagUsage builds the usage string for each command line option.
-func flagUsage(flag *Flag) string {
+func flagUsage(flag *Flag, width int, color bool) string {
 	// Get optional unquote usage
-	name, usage := unquoteUsage(flag)
+	name, s := unquoteUsage(flag)
 
-	s := fmt.Sprintf("\n  -%s, --%s %s\n     %s",
-		flag.shortName, flag.key, name, usage)
+	if flag.note != "" {
+		s += fmt.Sprintf(" (%s)", flag.note)
+	}
+
+	if flag.percentOf > 0 {
+		s += fmt.Sprintf(" (a size such as 512MB, or a percentage of %d such as 25%%)",
+			flag.percentOf)
+	}
+
+	if flag.schema != nil {
+		keys := make([]string, 0, len(flag.schema))
+		for k, t := range flag.schema {
+			keys = append(keys, k+"="+typeName(t))
+		}
+		sort.Strings(keys)
+		s += fmt.Sprintf(" (keys: %s)", strings.Join(keys, ", "))
+	}
+
+	if flag.choicesFn != nil {
+		s += fmt.Sprintf(" [%s]", strings.Join(flag.choicesFn(), "|"))
+	}
 
 	if flag.defaultValue != nil {
-		s += flagDefaultValue(flag)
+		if d := flagDefaultValue(flag); d != "" {
+			s += " " + paint(strings.TrimPrefix(d, " "), colorDefault, color)
+		}
+	}
+
+	if flag.bounds != nil {
+		s += fmt.Sprintf(" (range=[%d,%d])", flag.bounds[0], flag.bounds[1])
+	}
+
+	return fmt.Sprintf("\n  %s %s\n", paint(flag.names(), colorName, color), name) +
+		wrapText(s, "     ", width)
+}
+
+// ANSI escape sequences coloring parts of the usage
+const (
+	colorName    = "\x1b[1;36m" // Flag names, bold cyan
+	colorDefault = "\x1b[2m"    // Default values, dim
+	colorReset   = "\x1b[0m"
+)
+
+// ansiEscape matches the ANSI escape sequences used to color usage
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// paint wraps s in the escape sequence code if color is on
+func paint(s, code string, color bool) string {
+	if !color {
+		return s
+	}
+	return code + s + colorReset
+}
+
+// wrapText word-wraps text so that no line, including its indent, is
+// longer than width where possible. Zero width does not wrap.
+func wrapText(text, indent string, width int) string {
+	if width <= 0 {
+		return indent + text
+	}
+
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(indent)+visibleLen(line)+1+visibleLen(word) > width {
+			lines = append(lines, indent+line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	lines = append(lines, indent+line)
+
+	return strings.Join(lines, "\n")
+}
+
+// visibleLen returns the length of s as displayed, without color
+func visibleLen(s string) int {
+	return len(ansiEscape.ReplaceAllString(s, ""))
+}
+
+// orderedFlags returns the flags in the order selected by SetUsageSort
+func (fs *FlagSet) orderedFlags() []*Flag {
+	flags := sortFlags(fs.flag)
+	if fs.usageSort == UsageSortDeclaration {
+		sort.Slice(flags, func(i, j int) bool {
+			return flags[i].order < flags[j].order
+		})
+	}
+	return flags
+}
+
+// visibleFlags returns the flags shown by Usage in the order selected
+// by SetUsageSort
+func (fs *FlagSet) visibleFlags() []*Flag {
+	var visible []*Flag
+	for _, f := range fs.orderedFlags() {
+		if !f.hidden {
+			visible = append(visible, f)
+		}
+	}
+	return visible
+}
+
+// names renders the short and long names of a flag for usage
+func (f *Flag) names() string {
+	s := "--" + f.key
+	if f.shortName != "" {
+		s = fmt.Sprintf("-%s, --%s", f.shortName, f.key)
+	}
+	for _, alias := range f.aliases {
+		s += ", --" + alias
+	}
+	return s
+}
+
+// synopsis builds the bracketed flag summary of the "Usage:" line,
+// collapsed to "[options]" when there are too many flags to read
+func (fs *FlagSet) synopsis() string {
+	if fs.synopsisMax > 0 && len(fs.visibleFlags()) > fs.synopsisMax {
+		return " [options]"
+	}
+
+	s := " [-"
+	for _, f := range fs.visibleFlags() {
+		if f.shortName != "" {
+			s += f.shortName
+		} else {
+			s += "-" + f.key
+		}
+		// Get optional unquote usage
+		if n, _ := unquoteUsage(f); n != "" {
+			s += fmt.Sprintf(" %s", n)
+		}
+
+		s += "|"
+	}
+	s = strings.TrimRight(s, "|")
+	s += "]"
+
+	return s
+}
+
+// options builds the option descriptions of the usage, in sections
+// according to the usage style
+func (fs *FlagSet) options(color bool) string {
+	var s string
+
+	switch fs.usageStyle {
+	case UsageStyleRequiredFirst:
+		var required, optional string
+		for _, f := range fs.visibleFlags() {
+			if f.required {
+				required += flagUsage(f, fs.usageWidth, color)
+			} else {
+				optional += flagUsage(f, fs.usageWidth, color)
+			}
+		}
+		if required != "" {
+			s += "\nRequired:" + required
+		}
+		if optional != "" {
+			s += "\nOptional:" + optional
+		}
+	default:
+		// Ungrouped flags first, then each group in sorted order
+		groups := make(map[string]string)
+		for _, f := range fs.visibleFlags() {
+			groups[f.group] += flagUsage(f, fs.usageWidth, color)
+		}
+		if options, ok := groups[""]; ok {
+			s += "\nOptions:" + options
+		}
+		names := make([]string, 0, len(groups))
+		for name := range groups {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			s += fmt.Sprintf("\n%s:%s", name, groups[name])
+		}
 	}
 
 	return s
 }
 
+// AddAlias adds another long name for a flag, such as "colour" for
+// "color". The alias may be used on the command line and with the
+// getters.
+func (fs *FlagSet) AddAlias(key, alias string) error {
+	alias = strings.TrimPrefix(alias, "--")
+	if err := validateName(alias); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+	if other := fs.flagByName(alias); other != nil {
+		return fmt.Errorf("%q: already defined by %s flag %q", alias, other.flagType, other.key)
+	}
+
+	fs.coreFlagSet.Var(fs.coreFlagSet.Lookup(key).Value, alias, f.usage)
+	f.aliases = append(f.aliases, alias)
+	return nil
+}
+
+// SetGroup lists a flag in Usage under a heading of its own, such as
+// "Network", rather than under "Options:". Groups are shown in sorted
+// order after the ungrouped flags. They apply to the default usage
+// style only.
+func (fs *FlagSet) SetGroup(key, group string) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+
+	f.group = group
+	return nil
+}
+
+// SetUsageStyle selects how Usage lays out the option descriptions,
+// one of UsageStyleDefault or UsageStyleRequiredFirst
+func (fs *FlagSet) SetUsageStyle(style string) error {
+	switch style {
+	case UsageStyleDefault, UsageStyleRequiredFirst:
+		fs.usageStyle = style
+		return nil
+	}
+
+	return fmt.Errorf("%q: unknown usage style", style)
+}
+
+// SetUsageTemplate replaces the built-in format of Usage with a
+// text/template. The template is executed with the fields Name and
+// Description of the FlagSet and Flags, the visible flags, whose Key,
+// ShortName, TypeName, Default and Usage methods describe each flag.
+// If the template fails to execute, Usage uses the built-in format.
+func (fs *FlagSet) SetUsageTemplate(tmpl string) error {
+	t, err := template.New(fs.name).Parse(tmpl)
+	if err != nil {
+		return err
+	}
+
+	fs.usageTemplate = t
+	return nil
+}
+
+// SetColor sets whether usage colors flag names and defaults with ANSI
+// escape sequences. By default the string returned by Usage is never
+// colored, while the usage Parse writes on --help or an error is
+// colored only when the output is a terminal and the NO_COLOR
+// environment variable is not set.
+func (fs *FlagSet) SetColor(color bool) {
+	fs.color = &color
+}
+
+// useColor reports whether usage written to the output is colored,
+// detecting a terminal if SetColor has not been called
+func (fs *FlagSet) useColor() bool {
+	if fs.color != nil {
+		return *fs.color
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := fs.Output().(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// SetUsageSort selects the order Usage lists flags in, one of
+// UsageSortAlphabetical, the default, or UsageSortDeclaration
+func (fs *FlagSet) SetUsageSort(mode string) error {
+	switch mode {
+	case UsageSortAlphabetical, UsageSortDeclaration:
+		fs.usageSort = mode
+		return nil
+	}
+
+	return fmt.Errorf("%q: unknown usage sort", mode)
+}
+
+// SetUsageWidth sets the column at which Usage wraps flag
+// descriptions, indenting continuation lines under the first. The
+// default is 80; zero or less never wraps.
+func (fs *FlagSet) SetUsageWidth(cols int) {
+	fs.usageWidth = cols
+}
+
+// SetSynopsisThreshold sets the number of flags above which the Usage
+// synopsis collapses to "[options]". Zero or less never collapses.
+func (fs *FlagSet) SetSynopsisThreshold(n int) {
+	fs.synopsisMax = n
+}
+
 // Usage prints program usage information
 func (fs *FlagSet) Usage() string {
+	return fs.usage(fs.color != nil && *fs.color)
+}
+
+// printUsage writes the usage to the output, colored if the output is
+// a terminal
+func (fs *FlagSet) printUsage() {
+	fmt.Fprintln(fs.Output(), fs.usage(fs.useColor()))
+}
+
+// usage builds the usage returned by Usage
+func (fs *FlagSet) usage(color bool) string {
+	if fs.usageTemplate != nil {
+		var b strings.Builder
+		data := struct {
+			Name        string
+			Description string
+			Flags       []*Flag
+		}{fs.name, fs.description, fs.visibleFlags()}
+		if err := fs.usageTemplate.Execute(&b, data); err == nil {
+			return b.String()
+		}
+	}
+
 	var s string
 
 	// Optional description
@@ -372,42 +3289,125 @@ func (fs *FlagSet) Usage() string {
 
 	// Summary "Usage: ..." statement
 	s += fmt.Sprintf("Usage:\n  %s", fs.name)
-	if len(fs.flag) > 0 {
-		s += " [-"
-		for _, f := range sortFlags(fs.flag) {
-			s += f.shortName
-			// Get optional unquote usage
-			if n, _ := unquoteUsage(f); n != "" {
-				s += fmt.Sprintf(" %s", n)
-			}
-
-			s += "|"
-		}
-		s = strings.TrimRight(s, "|")
-		s += "]"
+	visible := len(fs.visibleFlags()) > 0
+	if visible {
+		s += fs.synopsis()
 		if fs.semantics != "" {
 			s += fmt.Sprintf(" %s", fs.semantics)
 		}
 	}
+	for _, p := range fs.positionals {
+		s += " " + p.String()
+	}
+	if len(fs.subcommands) > 0 {
+		s += " <command>"
+	}
 
 	// Full option description
-	if len(fs.flag) > 0 {
-		s += "\nOptions:"
-		for _, f := range sortFlags(fs.flag) {
-			s += flagUsage(f)
+	if visible {
+		s += fs.options(color)
+	}
+
+	// Declared positional arguments
+	if len(fs.positionals) > 0 {
+		s += "\nArguments:"
+		for _, p := range fs.positionals {
+			s += fmt.Sprintf("\n  %s\n", p) + wrapText(p.usage, "     ", fs.usageWidth)
+		}
+	}
+
+	// Subcommands dispatched by ParseWithSubcommands
+	if len(fs.subcommands) > 0 {
+		s += fs.commands()
+	}
+
+	// Optional example invocations
+	if len(fs.examples) > 0 {
+		s += "\nExamples:"
+		for _, e := range fs.examples {
+			s += fmt.Sprintf("\n  %s\n     %s", e.description, e.commandLine)
+		}
+	}
+
+	return s
+}
+
+// VerboseUsage returns developer-facing usage describing every flag
+// with its type, default, environment binding and constraints
+func (fs *FlagSet) VerboseUsage() string {
+	s := fmt.Sprintf("Flags of %q:", fs.name)
+	for _, f := range sortFlags(fs.flag) {
+		s += fmt.Sprintf("\n  %s\n     %s", f.names(), f.usage)
+		s += fmt.Sprintf("\n     type: %s", f.flagType)
+		if f.flagType != BASE {
+			s += fmt.Sprintf("\n     default: %s", f.format(f.defaultValue))
+		}
+		if f.envBound {
+			s += fmt.Sprintf("\n     env: %s", fs.envName(f))
+		}
+		if f.hidden {
+			s += "\n     hidden"
+		}
+		if f.required {
+			s += "\n     required"
+		}
+		if len(f.requiredFor) > 0 {
+			s += "\n     required for: " + strings.Join(f.requiredFor, ", ")
+		}
+		if f.nonEmpty {
+			s += "\n     non-empty"
+		}
+		if f.choicesFn != nil {
+			s += fmt.Sprintf("\n     choices: %s", strings.Join(f.choicesFn(), "|"))
+		}
+		for _, c := range f.constraints() {
+			s += "\n     " + c
 		}
 	}
 
 	return s
 }
 
+// constraints describes the value constraints of a flag not covered
+// by VerboseUsage directly
+func (f *Flag) constraints() []string {
+	var c []string
+	if f.schema != nil {
+		keys := make([]string, 0, len(f.schema))
+		for k, t := range f.schema {
+			keys = append(keys, k+"="+typeName(t))
+		}
+		sort.Strings(keys)
+		c = append(c, "keys: "+strings.Join(keys, ", "))
+	}
+	if f.bounds != nil {
+		c = append(c, fmt.Sprintf("range: [%d,%d]", f.bounds[0], f.bounds[1]))
+	}
+	if f.percentOf > 0 {
+		c = append(c, fmt.Sprintf("percent of: %d", f.percentOf))
+	}
+	if f.note != "" {
+		c = append(c, f.note)
+	}
+	return c
+}
+
 // NewFlagSet returns a new, empty flag set
 func NewFlagSet(name ...string) *FlagSet {
 	// Allow multiple names (or no name) to be the set name
 	f := &FlagSet{name: strings.Join(name, "|")}
 
+	// Collapse the usage synopsis past this many flags
+	f.synopsisMax = defaultSynopsisThreshold
+
+	// Wrap usage descriptions for the typical terminal
+	f.usageWidth = defaultUsageWidth
+
 	// Create the flag map, preallocate space for 64 flags
 	f.flag = make(map[string]*Flag, 64)
 
+	// Report core parse errors with the usage of the FlagSet
+	f.coreFlagSet.Usage = f.printUsage
+
 	return f
 }
@@ -1,9 +1,21 @@
 package flagplus
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // initializeFlagSet creates a new FlagSet for test suite
@@ -233,3 +245,2491 @@ func TestFlagSetDescription(t *testing.T) {
 		t.Errorf("Expected description %q but got %q", expect, got.description)
 	}
 }
+
+func TestFlagSet_Snapshot(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.Parse("util", "-o", "foo")
+
+	snap := flags.Snapshot()
+
+	// Modify values after the snapshot
+	flags.SimulateArg("output", "bar")
+	flags.SimulateArg("line", "42")
+
+	if err := flags.RestoreSnapshot(snap); err != nil {
+		t.Fatalf("Could not restore snapshot: %v", err)
+	}
+
+	gotStr, _ := flags.GetString("output")
+	if gotStr != "foo" {
+		t.Errorf("Expected %q, got %q", "foo", gotStr)
+	}
+	gotInt, _ := flags.GetInt("line")
+	if gotInt != 1 {
+		t.Errorf("Expected %v, got %v", 1, gotInt)
+	}
+	if !flags.flag["output"].isSet || flags.flag["line"].isSet {
+		t.Error("Expected set-state to be restored")
+	}
+
+	// A snapshot from another FlagSet is rejected
+	other := initalizeFlagSet()
+	if err := other.RestoreSnapshot(snap); err == nil {
+		t.Error("Expected error restoring a foreign snapshot")
+	}
+
+	// A flag added after the snapshot leaves every value unchanged
+	flags.AddBoolFlag("debug", "d", "Debug output", false)
+	for _, key := range []string{"output", "line"} {
+		for i := 0; i < 10; i++ {
+			flags.SimulateArg(key, "7")
+			if err := flags.RestoreSnapshot(snap); err == nil {
+				t.Fatal("Expected error for a flag not in the snapshot")
+			}
+			if got, _ := flags.GetAsString(key); got != "7" {
+				t.Errorf("Expected %q to be unchanged, got %q", key, got)
+			}
+		}
+	}
+}
+
+func TestFlagSet_AddStringChoiceFuncFlag(t *testing.T) {
+	plugins := func() []string {
+		return []string{"gzip", "zstd"}
+	}
+
+	// Valid value
+	flags := initalizeFlagSet()
+	flags.AddStringChoiceFuncFlag("codec", "c", "Compression `plugin`", "gzip", plugins)
+	if err := flags.Parse("util", "-c", "zstd"); err != nil {
+		t.Fatalf("Could not parse valid choice: %v", err)
+	}
+	got, _ := flags.GetString("codec")
+	if got != "zstd" {
+		t.Errorf("Expected %q, got %q", "zstd", got)
+	}
+
+	// Invalid value lists the computed choices
+	flags = initalizeFlagSet()
+	flags.AddStringChoiceFuncFlag("codec", "c", "Compression `plugin`", "gzip", plugins)
+	err := flags.Parse("util", "-c", "lz4")
+	if err == nil {
+		t.Fatal("Expected error for invalid choice")
+	}
+	if !strings.Contains(err.Error(), "[gzip|zstd]") {
+		t.Errorf("Expected choices in error, got %q", err)
+	}
+
+	// Usage lists the choices
+	if !strings.Contains(flags.Usage(), "[gzip|zstd]") {
+		t.Errorf("Expected choices in usage, got %q", flags.Usage())
+	}
+}
+
+func TestFlagSet_AddFlag_Duplicate(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+
+	tests := []struct {
+		add    func() error
+		expect string
+	}{
+		{func() error { return flags.AddIntFlag("output", "n", "Count", 0) },
+			`"output": already defined by STRING flag "output"`},
+		{func() error { return flags.AddFlag("overwrite", "o", "Overwrite") },
+			`"o": already defined by STRING flag "output"`},
+		{func() error { return flags.AddFlag("o", "", "Other") },
+			`"o": already defined by STRING flag "output"`},
+		{func() error { return flags.AddFlag("x", "x", "Same") },
+			`"x": short name is the same as the key`},
+	}
+	for _, test := range tests {
+		if err := test.add(); err == nil || err.Error() != test.expect {
+			t.Errorf("Expected %q, got %v", test.expect, err)
+		}
+	}
+
+	// The original flag is untouched
+	flags.Parse("util", "-o", "a.txt")
+	if output, _ := flags.GetString("output"); output != "a.txt" {
+		t.Errorf("Expected %q, got %q", "a.txt", output)
+	}
+}
+
+func TestFlagSet_SetUsageSort(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+
+	order := func() string {
+		var names []string
+		for _, line := range strings.Split(flags.Usage(), "\n") {
+			if strings.HasPrefix(line, "  -") {
+				names = append(names, strings.Fields(line)[0])
+			}
+		}
+		return strings.Join(names, " ")
+	}
+
+	if got := order(); got != "-l, -o, -v," {
+		t.Errorf("Expected alphabetical order, got %q", got)
+	}
+	if err := flags.SetUsageSort(UsageSortDeclaration); err != nil {
+		t.Fatal(err)
+	}
+	if got := order(); got != "-o, -v, -l," {
+		t.Errorf("Expected declaration order, got %q", got)
+	}
+	if !strings.Contains(flags.Usage(), "util [-o string|v bool|l int]") {
+		t.Errorf("Expected declaration order in synopsis, got %q", flags.Usage())
+	}
+
+	if err := flags.SetUsageSort("random"); err == nil {
+		t.Error("Expected error for unknown sort")
+	}
+}
+
+func TestFlagSet_SetColor(t *testing.T) {
+	setup := func() *FlagSet {
+		flags := initalizeFlagSet()
+		flags.SetOutput(new(bytes.Buffer))
+		flags.AddIntFlag("line", "l", "Line Number", 1)
+		flags.AddStringFlag("output", "", "Output file", "")
+		return flags
+	}
+
+	plain := setup().Usage()
+	flags := setup()
+	flags.SetColor(false)
+	if got := flags.Usage(); got != plain {
+		t.Errorf("Expected %q, got %q", plain, got)
+	}
+
+	flags.SetColor(true)
+	colored := flags.Usage()
+	for _, expect := range []string{
+		"\x1b[1;36m-l, --line\x1b[0m int\n",
+		"Line Number \x1b[2m(default=1)\x1b[0m",
+		"\x1b[1;36m--output\x1b[0m string\n",
+	} {
+		if !strings.Contains(colored, expect) {
+			t.Errorf("Expected %q in usage, got %q", expect, colored)
+		}
+	}
+	if stripped := ansiEscape.ReplaceAllString(colored, ""); stripped != plain {
+		t.Errorf("Expected %q without colors, got %q", plain, stripped)
+	}
+
+	// Usage written by Parse follows the same setting
+	for _, color := range []bool{false, true} {
+		var out bytes.Buffer
+		flags := setup()
+		flags.SetOutput(&out)
+		flags.EnableHelp()
+		flags.SetColor(color)
+		flags.Parse("util", "--help")
+		if got := strings.Contains(out.String(), "\x1b["); got != color {
+			t.Errorf("Expected colored %v, got %q", color, out.String())
+		}
+	}
+}
+
+func TestFlagSet_SetUsageTemplate(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line `number`", 1)
+	flags.AddStringFlag("output", "", "Output file", "")
+	flags.AddBoolFlag("debug", "d", "Debug output", false)
+	flags.MarkHidden("debug")
+	flags.FlagSetDescription("This utility does something")
+
+	tmpl := `{{.Name}}: {{.Description}}
+{{range .Flags}}{{if .ShortName}}-{{.ShortName}}|{{end}}--{{.Key}} <{{.TypeName}}>` +
+		`{{with .Default}} [{{.}}]{{end}}  {{.Usage}}
+{{end}}`
+	if err := flags.SetUsageTemplate(tmpl); err != nil {
+		t.Fatalf("Could not set usage template: %v", err)
+	}
+
+	expect := "util: This utility does something\n" +
+		"-l|--line <number> [1]  Line `number`\n" +
+		"--output <string>  Output file\n"
+	if got := flags.Usage(); got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	if err := flags.SetUsageTemplate("{{.Name"); err == nil {
+		t.Error("Expected error for malformed template")
+	}
+}
+
+func TestFlagSet_AddAlias(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddBoolFlag("color", "c", "Colored output", false)
+	flags.AddStringFlag("output", "o", "Output file", "")
+	if err := flags.AddAlias("color", "colour"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `"output": already defined by STRING flag "output"`
+	if err := flags.AddAlias("color", "output"); err == nil || err.Error() != expect {
+		t.Errorf("Expected %q, got %v", expect, err)
+	}
+	if !strings.Contains(flags.Usage(), "\n  -c, --color, --colour bool\n") {
+		t.Errorf("Expected alias in usage, got %q", flags.Usage())
+	}
+
+	if err := flags.Parse("util", "--colour"); err != nil {
+		t.Fatalf("Could not parse alias: %v", err)
+	}
+	if color, _ := flags.GetBool("color"); !color {
+		t.Error("Expected alias to set color")
+	}
+	if colour, explicit, _ := flags.BoolState("colour"); !colour || !explicit {
+		t.Error("Expected alias to resolve in getters")
+	}
+}
+
+func TestFlagSet_WasSet(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.AddIntFlag("retries", "r", "Number of retries", 3)
+	if flags.WasSet("verbose") {
+		t.Error("Expected verbose not to be set before Parse")
+	}
+
+	if err := flags.Parse("util", "--verbose=false"); err != nil {
+		t.Fatal(err)
+	}
+	if !flags.WasSet("verbose") {
+		t.Error("Expected verbose to be set to its default")
+	}
+	if flags.WasSet("retries") {
+		t.Error("Expected retries not to be set")
+	}
+	if flags.WasSet("missing") {
+		t.Error("Expected unknown flag not to be set")
+	}
+}
+
+func TestFlagSet_AddEnumFlag(t *testing.T) {
+	modes := []string{"fast", "safe", "debug"}
+
+	// Default outside the choices is rejected up front
+	flags := initalizeFlagSet()
+	if err := flags.AddEnumFlag("mode", "m", "Run mode", modes, "slow"); err == nil {
+		t.Error("Expected error for default outside choices")
+	}
+
+	flags = initalizeFlagSet()
+	if err := flags.AddEnumFlag("mode", "m", "Run mode", modes, "fast"); err != nil {
+		t.Fatalf("Could not add enum flag: %v", err)
+	}
+	if !strings.Contains(flags.Usage(), "[fast|safe|debug]") {
+		t.Errorf("Expected choices in usage, got %q", flags.Usage())
+	}
+
+	err := flags.Parse("util", "--mode", "turbo")
+	if err == nil {
+		t.Fatal("Expected error for invalid choice")
+	}
+	expect := `"mode": invalid value "turbo", must be one of [fast|safe|debug]`
+	if err.Error() != expect {
+		t.Errorf("Expected %q, got %q", expect, err)
+	}
+
+	flags = initalizeFlagSet()
+	flags.AddEnumFlag("mode", "m", "Run mode", modes, "fast")
+	if err := flags.Parse("util", "-m", "debug"); err != nil {
+		t.Fatalf("Could not parse valid choice: %v", err)
+	}
+	if got, _ := flags.GetString("mode"); got != "debug" {
+		t.Errorf("Expected %q, got %q", "debug", got)
+	}
+}
+
+func TestFlagSet_AddIntFlagRange(t *testing.T) {
+	// Default outside the range is rejected up front
+	flags := initalizeFlagSet()
+	if err := flags.AddIntFlagRange("workers", "w", "Worker count", 0, 1, 64); err == nil {
+		t.Error("Expected error for default outside range")
+	}
+
+	flags = initalizeFlagSet()
+	if err := flags.AddIntFlagRange("workers", "w", "Worker count", 4, 1, 64); err != nil {
+		t.Fatalf("Could not add range flag: %v", err)
+	}
+	if !strings.Contains(flags.Usage(), "(default=4) (range=[1,64])") {
+		t.Errorf("Expected range in usage, got %q", flags.Usage())
+	}
+
+	err := flags.Parse("util", "--workers", "100")
+	if err == nil {
+		t.Fatal("Expected error for out of range value")
+	}
+	expect := `"workers": value 100 out of range [1,64]`
+	if err.Error() != expect {
+		t.Errorf("Expected %q, got %q", expect, err)
+	}
+
+	flags = initalizeFlagSet()
+	flags.AddIntFlagRange("workers", "w", "Worker count", 4, 1, 64)
+	if err := flags.Parse("util", "-w", "64"); err != nil {
+		t.Fatalf("Could not parse value in range: %v", err)
+	}
+	if got, _ := flags.GetInt("workers"); got != 64 {
+		t.Errorf("Expected %d, got %d", 64, got)
+	}
+}
+
+func TestFlagSet_AddSortSpecFlag(t *testing.T) {
+	fields := []string{"name", "date"}
+
+	flags := initalizeFlagSet()
+	flags.AddSortSpecFlag("order", "o", "Sort order", fields)
+	if err := flags.Parse("util", "--order", "name,-date"); err != nil {
+		t.Fatalf("Could not parse sort spec: %v", err)
+	}
+	got, err := flags.GetSortSpec("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []SortField{{"name", false}, {"date", true}}
+	if fmt.Sprint(got) != fmt.Sprint(expect) {
+		t.Errorf("Expected %v, got %v", expect, got)
+	}
+	if s, _ := flags.GetAsString("order"); s != "name,-date" {
+		t.Errorf("Expected %q, got %q", "name,-date", s)
+	}
+
+	// Unknown fields are parse errors
+	flags = initalizeFlagSet()
+	flags.AddSortSpecFlag("order", "o", "Sort order", fields)
+	if err := flags.Parse("util", "-o", "name,-size"); err == nil {
+		t.Error("Expected error for unknown sort field")
+	}
+}
+
+func TestFlagSet_AddIntSliceFlag(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect string
+		err    string
+	}{
+		{[]string{}, "[80]", ""},
+		{[]string{"--ports", "80,443,8080"}, "[80 443 8080]", ""},
+		{[]string{"-p", "80", "-p", "443"}, "[80 443]", ""},
+		{[]string{"-p", "22", "-p", "80,443"}, "[22 80 443]", ""},
+		{[]string{"-p", "80,http"}, "", `invalid integer "http"`},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.SetOutput(new(bytes.Buffer))
+		flags.AddIntSliceFlag("ports", "p", "Ports to open", []int64{80})
+		err := flags.Parse(append([]string{"util"}, test.args...)...)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: Expected %q, got %v", test.args, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: Could not parse: %v", test.args, err)
+		}
+		got, _ := flags.GetIntSlice("ports")
+		if fmt.Sprint(got) != test.expect {
+			t.Errorf("%v: Expected %s, got %v", test.args, test.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_AddRangeListFlag(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect string
+		err    bool
+	}{
+		{[]string{"--pages", "1-3,5,7-9"}, "[1 2 3 5 7 8 9]", false},
+		{[]string{"-p", "5,1-3", "-p", "2-6"}, "[1 2 3 4 5 6]", false},
+		{[]string{"-p", "5-1"}, "", true},
+		{[]string{"-p", "1,x"}, "", true},
+		{[]string{"-p", "1-1000000000"}, "", true},
+		{[]string{"-p", "9223372036854775806-9223372036854775807"}, "[9223372036854775806 9223372036854775807]", false},
+		{[]string{"-p", "9223372036854775807"}, "[9223372036854775807]", false},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddRangeListFlag("pages", "p", "Pages to print")
+		err := flags.Parse(append([]string{"util"}, test.args...)...)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: Expected error", test.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: Could not parse: %v", test.args, err)
+		}
+		got, _ := flags.GetIntSlice("pages")
+		if fmt.Sprint(got) != test.expect {
+			t.Errorf("Expected %s, got %v", test.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_AddCountFlag(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect int
+	}{
+		{[]string{}, 0},
+		{[]string{"-v"}, 1},
+		{[]string{"-v", "-v", "--verbose"}, 3},
+		{[]string{"-vvv"}, 3},
+		{[]string{"-vv", "-q", "-v"}, 3},
+		{[]string{"-vv", "-v=false", "-v"}, 1},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddCountFlag("verbose", "v", "Verbosity level")
+		flags.AddFlag("quiet", "q", "No output")
+		if err := flags.Parse(append([]string{"util"}, test.args...)...); err != nil {
+			t.Fatalf("%v: Could not parse: %v", test.args, err)
+		}
+		if got, _ := flags.GetCount("verbose"); got != test.expect {
+			t.Errorf("%v: Expected %d, got %d", test.args, test.expect, got)
+		}
+	}
+
+	flags := initalizeFlagSet()
+	flags.AddCountFlag("verbose", "v", "Verbosity level")
+	if !strings.Contains(flags.Usage(), "Verbosity level (repeatable)") {
+		t.Errorf("Expected repeatable in usage, got %q", flags.Usage())
+	}
+}
+
+func TestFlagSet_AddIPFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIPFlag("bind", "b", "Listen address", net.IPv4zero)
+	if !strings.Contains(flags.Usage(), "--bind ip\n     Listen address (default=0.0.0.0)") {
+		t.Errorf("Expected ip default in usage, got %q", flags.Usage())
+	}
+
+	if err := flags.Parse("util", "--bind", "::1"); err != nil {
+		t.Fatalf("Could not parse IP: %v", err)
+	}
+	if ip, _ := flags.GetIP("bind"); !ip.Equal(net.IPv6loopback) {
+		t.Errorf("Expected %v, got %v", net.IPv6loopback, ip)
+	}
+
+	flags = initalizeFlagSet()
+	flags.SetOutput(new(bytes.Buffer))
+	flags.AddIPFlag("bind", "b", "Listen address", nil)
+	err := flags.Parse("util", "-b", "300.1.1.1")
+	if err == nil || !strings.Contains(err.Error(), "-b: invalid IP address") {
+		t.Errorf("Expected invalid IP error naming the flag, got %v", err)
+	}
+}
+
+func TestFlagSet_AddFilePathFlag(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.yml")
+	if err := os.WriteFile(file, []byte("debug: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := initalizeFlagSet()
+	flags.AddFilePathFlag("config", "c", "Config file", "", true)
+	if !strings.Contains(flags.Usage(), "--config path\n") {
+		t.Errorf("Expected path placeholder in usage, got %q", flags.Usage())
+	}
+	if err := flags.Parse("util", "--config", file); err != nil {
+		t.Fatalf("Could not parse existing file: %v", err)
+	}
+	if got, _ := flags.GetFilePath("config"); got != file {
+		t.Errorf("Expected %q, got %q", file, got)
+	}
+
+	for arg, expect := range map[string]string{
+		filepath.Join(dir, "missing.yml"): "no such file or directory",
+		dir:                               "is a directory",
+	} {
+		flags = initalizeFlagSet()
+		flags.SetOutput(new(bytes.Buffer))
+		flags.AddFilePathFlag("config", "c", "Config file", "", true)
+		if err := flags.Parse("util", "-c", arg); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("Expected %q, got %v", expect, err)
+		}
+	}
+
+	// Paths are not checked unless they must exist
+	flags = initalizeFlagSet()
+	flags.AddFilePathFlag("out", "o", "Output file", "", false)
+	if err := flags.Parse("util", "-o", filepath.Join(dir, "new.txt")); err != nil {
+		t.Errorf("Expected unchecked path, got %v", err)
+	}
+}
+
+func TestFlagSet_AddURLFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	def, _ := url.Parse("https://api.example.com")
+	flags.AddURLFlag("endpoint", "e", "API endpoint", def)
+	if !strings.Contains(flags.Usage(), "--endpoint url\n     API endpoint (default=https://api.example.com)") {
+		t.Errorf("Expected url default in usage, got %q", flags.Usage())
+	}
+	if err := flags.Parse("util", "--endpoint", "http://localhost:8080/v1"); err != nil {
+		t.Fatalf("Could not parse URL: %v", err)
+	}
+	if u, _ := flags.GetURL("endpoint"); u.Host != "localhost:8080" || u.Path != "/v1" {
+		t.Errorf("Expected %q, got %v", "http://localhost:8080/v1", u)
+	}
+
+	for _, arg := range []string{"api.example.com", "https://", "ftp://files.example.com"} {
+		flags = initalizeFlagSet()
+		flags.SetOutput(new(bytes.Buffer))
+		flags.AddURLFlag("endpoint", "e", "API endpoint", nil)
+		if err := flags.SetURLSchemes("endpoint", "http", "https"); err != nil {
+			t.Fatal(err)
+		}
+		if err := flags.Parse("util", "-e", arg); err == nil {
+			t.Errorf("Expected %q to be rejected", arg)
+		}
+	}
+
+	expect := `URL scheme "ftp" not allowed, must be one of [http|https]`
+	if err := flags.Parse("util", "-e", "ftp://files.example.com"); err == nil || !strings.Contains(err.Error(), expect) {
+		t.Errorf("Expected %q, got %v", expect, err)
+	}
+	if !strings.Contains(flags.Usage(), "(schemes: http, https)") {
+		t.Errorf("Expected schemes in usage, got %q", flags.Usage())
+	}
+}
+
+func TestFlagSet_AddRegexpFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddRegexpFlag("match", "m", "Lines to show", nil)
+	flags.AddRegexpFlag("skip", "s", "Lines to hide", regexp.MustCompile(`^DEBUG`))
+	if !strings.Contains(flags.Usage(), "--skip regexp\n     Lines to hide (default=^DEBUG)") {
+		t.Errorf("Expected regexp default in usage, got %q", flags.Usage())
+	}
+	if err := flags.Parse("util", "--match", "^ERROR"); err != nil {
+		t.Fatalf("Could not parse regexp: %v", err)
+	}
+	if re, _ := flags.GetRegexp("match"); re == nil || !re.MatchString("ERROR: disk full") {
+		t.Errorf("Expected %q, got %v", "^ERROR", re)
+	}
+	if re, _ := flags.GetRegexp("skip"); re.String() != "^DEBUG" {
+		t.Errorf("Expected %q, got %v", "^DEBUG", re)
+	}
+
+	flags = initalizeFlagSet()
+	flags.SetOutput(new(bytes.Buffer))
+	flags.AddRegexpFlag("match", "m", "Lines to show", nil)
+	err := flags.Parse("util", "-m", "(")
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Flag != "m" {
+		t.Errorf("Expected parse error naming the flag, got %v", err)
+	}
+}
+
+func TestFlagSet_AddTimeFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddTimeFlag("start", "s", "Start time", "", time.Time{})
+	if !strings.Contains(flags.Usage(), "(layout: 2006-01-02T15:04:05Z07:00)") {
+		t.Errorf("Expected layout in usage, got %q", flags.Usage())
+	}
+	if err := flags.Parse("util", "--start", "2023-01-02T15:04:05Z"); err != nil {
+		t.Fatalf("Could not parse time: %v", err)
+	}
+	expect := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	if got, _ := flags.GetTime("start"); !got.Equal(expect) {
+		t.Errorf("Expected %v, got %v", expect, got)
+	}
+
+	// Custom layouts
+	flags = initalizeFlagSet()
+	flags.AddTimeFlag("day", "d", "Day", "2006-01-02", expect)
+	if !strings.Contains(flags.Usage(), "(default=2023-01-02)") {
+		t.Errorf("Expected formatted default in usage, got %q", flags.Usage())
+	}
+	if err := flags.Parse("util", "-d", "2024-02-29"); err != nil {
+		t.Fatalf("Could not parse time: %v", err)
+	}
+
+	flags = initalizeFlagSet()
+	flags.SetOutput(new(bytes.Buffer))
+	flags.AddTimeFlag("start", "s", "Start time", "", time.Time{})
+	if err := flags.Parse("util", "-s", "yesterday"); err == nil {
+		t.Error("Expected error for invalid time")
+	}
+}
+
+func TestFlagSet_GetIntClamped(t *testing.T) {
+	tests := []struct {
+		arg    string
+		expect int64
+	}{
+		{"-5", 1},
+		{"7", 7},
+		{"99", 10},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddIntFlag("level", "l", "Tuning level", 5)
+		flags.Parse("util", "--level", tc.arg)
+
+		got, err := flags.GetIntClamped("level", 1, 10)
+		if err != nil {
+			t.Fatalf("Could not get flag level: %v", err)
+		}
+		if got != tc.expect {
+			t.Errorf("Arg %q: expected %v, got %v", tc.arg, tc.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_SplitArgs(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.AddStringFlag("output", "o", "Output `directory`", "")
+
+	args := []string{"-v", "--plugin-opt", "x", "-o", "/tmp", "--level=3",
+		"--output=/var", "file", "--", "-v"}
+	mine, rest := flags.SplitArgs(args)
+
+	expectMine := []string{"-v", "-o", "/tmp", "--output=/var"}
+	expectRest := []string{"--plugin-opt", "x", "--level=3", "file", "--", "-v"}
+
+	if strings.Join(mine, " ") != strings.Join(expectMine, " ") {
+		t.Errorf("Expected host args %q, got %q", expectMine, mine)
+	}
+	if strings.Join(rest, " ") != strings.Join(expectRest, " ") {
+		t.Errorf("Expected remaining args %q, got %q", expectRest, rest)
+	}
+}
+
+func TestFlagSet_AddTypedMapFlag(t *testing.T) {
+	schema := map[string]FlagType{"timeout": INT, "verbose": BOOL, "name": STRING}
+
+	flags := initalizeFlagSet()
+	flags.AddTypedMapFlag("param", "p", "Tuning `parameters`", schema)
+	if err := flags.Parse("util", "--param", "timeout=30,verbose=true", "-p", "name=x"); err != nil {
+		t.Fatalf("Could not parse typed map: %v", err)
+	}
+
+	got, err := flags.GetTypedMap("param")
+	if err != nil {
+		t.Fatalf("Could not get flag param: %v", err)
+	}
+	if got["timeout"] != int64(30) || got["verbose"] != true || got["name"] != "x" {
+		t.Errorf("Unexpected typed map %v", got)
+	}
+
+	if !strings.Contains(flags.Usage(), "name=string, timeout=int, verbose=bool") {
+		t.Errorf("Expected schema in usage, got %q", flags.Usage())
+	}
+
+	// Failed coercion and unknown key
+	for _, arg := range []string{"timeout=soon", "retries=3"} {
+		flags = initalizeFlagSet()
+		flags.AddTypedMapFlag("param", "p", "Tuning `parameters`", schema)
+		if err := flags.Parse("util", "--param", arg); err == nil {
+			t.Errorf("Expected error parsing %q", arg)
+		}
+	}
+}
+
+func TestFlagSet_SetSynopsisThreshold(t *testing.T) {
+	flags := initalizeFlagSet()
+	for i := 0; i < 10; i++ {
+		flags.AddFlag(fmt.Sprintf("flag%d", i), fmt.Sprintf("%d", i), "A flag")
+	}
+	if !strings.Contains(flags.Usage(), "util [options]\n") {
+		t.Errorf("Expected collapsed synopsis, got %q", flags.Usage())
+	}
+
+	flags.SetSynopsisThreshold(0)
+	if !strings.Contains(flags.Usage(), "util [-0|1|2") {
+		t.Errorf("Expected expanded synopsis, got %q", flags.Usage())
+	}
+
+	flags = initalizeFlagSet()
+	for i := 0; i < 3; i++ {
+		flags.AddFlag(fmt.Sprintf("flag%d", i), fmt.Sprintf("%d", i), "A flag")
+	}
+	if !strings.Contains(flags.Usage(), "util [-0|1|2]\n") {
+		t.Errorf("Expected expanded synopsis, got %q", flags.Usage())
+	}
+}
+
+func TestFlagSet_AddSemVerFlag(t *testing.T) {
+	tests := []struct {
+		arg                 string
+		major, minor, patch int
+		fail                bool
+	}{
+		{"1.2.3", 1, 2, 3, false},
+		{"1.2.3-rc1", 1, 2, 3, false},
+		{"1.x", 0, 0, 0, true},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddSemVerFlag("min-version", "m", "Minimum version", "0.0.1")
+		err := flags.Parse("util", "--min-version", tc.arg)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tc.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.arg, err)
+		}
+
+		major, minor, patch, err := flags.GetSemVer("min-version")
+		if err != nil {
+			t.Fatalf("Could not get flag min-version: %v", err)
+		}
+		if major != tc.major || minor != tc.minor || patch != tc.patch {
+			t.Errorf("Expected %d.%d.%d, got %d.%d.%d",
+				tc.major, tc.minor, tc.patch, major, minor, patch)
+		}
+	}
+}
+
+func TestFlagSet_SetPostParse(t *testing.T) {
+	calls := 0
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("log", "l", "Log `file`", "")
+	flags.SetPostParse(func(fs *FlagSet) error {
+		calls++
+		if v, _ := fs.GetString("log"); v == "" {
+			return fmt.Errorf("no log file")
+		}
+		return nil
+	})
+
+	if err := flags.Parse("util", "--log", "out.log"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected hook to run once, ran %d times", calls)
+	}
+
+	flags.SetAllowReparse(true)
+	flags.SimulateArg("log", "")
+	if err := flags.Parse("util"); err == nil || err.Error() != "no log file" {
+		t.Errorf("Expected hook error, got %v", err)
+	}
+}
+
+func TestFlagSet_SetNonEmpty(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	if err := flags.SetNonEmpty("output"); err != nil {
+		t.Fatalf("Could not mark flag non-empty: %v", err)
+	}
+	err := flags.Parse("util", "--output=")
+	if err == nil || !strings.Contains(err.Error(), `"output"`) {
+		t.Errorf("Expected error naming the flag, got %v", err)
+	}
+
+	// Omitting the flag uses the default
+	flags = initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.SetNonEmpty("output")
+	if err := flags.Parse("util"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	got, _ := flags.GetString("output")
+	if got != "/var/log/output" {
+		t.Errorf("Expected %q, got %q", "/var/log/output", got)
+	}
+
+	// Only string flags qualify
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	if err := flags.SetNonEmpty("line"); err == nil {
+		t.Error("Expected error marking an int flag non-empty")
+	}
+}
+
+func TestFlagSet_AddExample(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddFlag("help", "h", "Help")
+	flags.AddExample("Show help", "util -h")
+	flags.AddExample("Process files", "util a.txt b.txt")
+
+	expect := "\nExamples:\n  Show help\n     util -h\n  Process files\n     util a.txt b.txt"
+	if !strings.HasSuffix(flags.Usage(), expect) {
+		t.Errorf("Expected usage to end with %q, got %q", expect, flags.Usage())
+	}
+}
+
+func TestFlagSet_SetToDefault(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddFloatFlag("skew", "s", "Skew `percentage`", 2.5)
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.Parse("util", "--line", "1", "--skew", "3")
+
+	got := flags.SetToDefault()
+	if len(got) != 1 || got[0] != "line" {
+		t.Errorf("Expected [line], got %q", got)
+	}
+}
+
+func TestFlagSet_SetDisplayFunc(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("timeout", "t", "Timeout in `seconds`", 60)
+	err := flags.SetDisplayFunc("timeout", func(value interface{}) string {
+		d := time.Duration(value.(int64)) * time.Second
+		return strings.TrimSuffix(d.String(), "0s")
+	})
+	if err != nil {
+		t.Fatalf("Could not set display function: %v", err)
+	}
+	flags.Parse("util", "--timeout", "5400")
+
+	got, err := flags.GetAsString("timeout")
+	if err != nil {
+		t.Fatalf("Could not get flag timeout: %v", err)
+	}
+	if got != "1h30m" {
+		t.Errorf("Expected %q, got %q", "1h30m", got)
+	}
+
+	if !strings.Contains(flags.Usage(), "(default=1m)") {
+		t.Errorf("Expected custom default in usage, got %q", flags.Usage())
+	}
+
+	if err := flags.SetDisplayFunc("missing", nil); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_AddFlag_InvalidName(t *testing.T) {
+	flags := initalizeFlagSet()
+
+	for _, key := range []string{"foo bar", "foo=bar", "--foo", ""} {
+		if err := flags.AddFlag(key, "", "Invalid"); err == nil {
+			t.Errorf("Expected error for key %q", key)
+		}
+	}
+	if err := flags.AddFlag("foo", "f f", "Invalid"); err == nil {
+		t.Error("Expected error for short name with whitespace")
+	}
+	if _, ok := flags.flag["foo"]; ok {
+		t.Error("Expected invalid flag not to be added")
+	}
+
+	// A single leading dash on the short name is normalized
+	if err := flags.AddFlag("foo", "-f", "Valid"); err != nil {
+		t.Errorf("Could not add flag: %v", err)
+	}
+}
+
+func TestFlagSet_ViewStruct(t *testing.T) {
+	var config struct {
+		Output  string
+		Line    int64
+		Skew    float64 `flag:"skew-pct"`
+		Verbose bool
+		Other   string
+	}
+
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddFloatFlag("skew-pct", "s", "Skew `percentage`", 2.5)
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.Parse("util", "-l", "7", "-v")
+
+	if err := flags.ViewStruct(&config); err != nil {
+		t.Fatalf("Could not view struct: %v", err)
+	}
+	if config.Output != "/var/log/output" || config.Line != 7 ||
+		config.Skew != 2.5 || !config.Verbose || config.Other != "" {
+		t.Errorf("Unexpected struct %+v", config)
+	}
+
+	// Type mismatch
+	var bad struct {
+		Line string
+	}
+	if err := flags.ViewStruct(&bad); err == nil {
+		t.Error("Expected error for mismatched field type")
+	}
+}
+
+func TestFlagSet_AddBytesFlag(t *testing.T) {
+	tests := []struct {
+		arg    string
+		expect int64
+		fail   bool
+	}{
+		{"1024", 1024, false},
+		{"1KB", 1000, false},
+		{"1KiB", 1024, false},
+		{"10MB", 10 * 1000 * 1000, false},
+		{"1GiB", 1 << 30, false},
+		{"10XB", 0, true},
+		{"25%", 0, true},
+		{"10000000000GB", 0, true},
+		{"9223372036854775807", math.MaxInt64, false},
+		{"99999999999999999999", 0, true},
+		{"0.5TiB", 1 << 39, false},
+		{"9300000TB", 0, true},
+		{"9223372.1TB", 0, true},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.SetOutput(new(bytes.Buffer))
+		flags.AddBytesFlag("max-size", "m", "Largest file to keep", 0)
+		err := flags.Parse("util", "--max-size", tc.arg)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tc.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.arg, err)
+		}
+
+		if got, _ := flags.GetBytes("max-size"); got != tc.expect {
+			t.Errorf("Arg %q: expected %v, got %v", tc.arg, tc.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_AddPercentFlag(t *testing.T) {
+	tests := []struct {
+		arg    string
+		expect float64
+		fail   bool
+	}{
+		{"25", 25, false},
+		{"25%", 25, false},
+		{"0.5%", 0.5, false},
+		{"110", 0, true},
+		{"-1", 0, true},
+		{"half", 0, true},
+		{"NaN", 0, true},
+		{"nan%", 0, true},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.SetOutput(new(bytes.Buffer))
+		flags.AddPercentFlag("sample", "s", "Share of requests to trace", 10)
+		err := flags.Parse("util", "--sample", tc.arg)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tc.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.arg, err)
+		}
+
+		if got, _ := flags.GetPercent("sample"); got != tc.expect {
+			t.Errorf("Arg %q: expected %v, got %v", tc.arg, tc.expect, got)
+		}
+	}
+
+	flags := initalizeFlagSet()
+	if err := flags.AddPercentFlag("sample", "s", "Share of requests", 150); err == nil {
+		t.Error("Expected error for out of range default")
+	}
+	if err := flags.AddPercentFlag("ratio", "r", "Share of requests", math.NaN()); err == nil {
+		t.Error("Expected error for NaN default")
+	}
+}
+
+func TestFlagSet_AddBytesOrPercentFlag(t *testing.T) {
+	tests := []struct {
+		arg    string
+		expect int64
+		fail   bool
+	}{
+		{"25%", 250, false},
+		{"512MB", 512 * 1000 * 1000, false},
+		{"2KiB", 2048, false},
+		{"10XB", 0, true},
+		{"150%", 0, true},
+		{"NaN%", 0, true},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddBytesOrPercentFlag("cache", "c", "Cache `size`", 1000, 100)
+		err := flags.Parse("util", "--cache", tc.arg)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tc.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.arg, err)
+		}
+
+		got, err := flags.GetBytes("cache")
+		if err != nil {
+			t.Fatalf("Could not get flag cache: %v", err)
+		}
+		if got != tc.expect {
+			t.Errorf("Arg %q: expected %v, got %v", tc.arg, tc.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_InheritValues(t *testing.T) {
+	base := initalizeFlagSet()
+	base.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	base.AddIntFlag("line", "l", "Line Number", 1)
+	base.AddBoolFlag("verbose", "v", "Verbose output", false)
+	base.Parse("util", "-o", "/base", "-l", "10", "-v")
+
+	derived := initalizeFlagSet()
+	derived.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	derived.AddIntFlag("line", "l", "Line Number", 1)
+	derived.AddFloatFlag("skew", "s", "Skew `percentage`", 2.5)
+	derived.Parse("util", "-l", "20")
+
+	if err := derived.InheritValues(base); err != nil {
+		t.Fatalf("Could not inherit values: %v", err)
+	}
+
+	output, _ := derived.GetString("output")
+	if output != "/base" {
+		t.Errorf("Expected %q, got %q", "/base", output)
+	}
+	line, _ := derived.GetInt("line")
+	if line != 20 {
+		t.Errorf("Expected %v, got %v", 20, line)
+	}
+	if !strings.Contains(derived.Usage(), "(default=/base)") {
+		t.Errorf("Expected inherited default in usage, got %q", derived.Usage())
+	}
+
+	// Mismatched types error
+	other := initalizeFlagSet()
+	other.AddBoolFlag("line", "l", "Line Number", false)
+	if err := derived.InheritValues(other); err == nil {
+		t.Error("Expected error for mismatched flag type")
+	}
+}
+
+func TestFlagSet_BoolState(t *testing.T) {
+	tests := []struct {
+		args     []string
+		value    bool
+		explicit bool
+	}{
+		{[]string{"util"}, true, false},
+		{[]string{"util", "--color=true"}, true, true},
+		{[]string{"util", "--color=false"}, false, true},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddBoolFlag("color", "c", "Colorize output", true)
+		flags.Parse(tc.args...)
+
+		value, explicit, err := flags.BoolState("color")
+		if err != nil {
+			t.Fatalf("Could not get flag color: %v", err)
+		}
+		if value != tc.value || explicit != tc.explicit {
+			t.Errorf("Args %q: expected (%v, %v), got (%v, %v)",
+				tc.args, tc.value, tc.explicit, value, explicit)
+		}
+	}
+}
+
+func TestFlagSet_AddDurationFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddDurationFlag("timeout", "t", "Request `timeout`", 30*time.Second)
+	if err := flags.Parse("util", "--timeout", "1h30m"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	got, err := flags.GetDuration("timeout")
+	if err != nil {
+		t.Fatalf("Could not get flag timeout: %v", err)
+	}
+	if got != 90*time.Minute {
+		t.Errorf("Expected %v, got %v", 90*time.Minute, got)
+	}
+	if !strings.Contains(flags.Usage(), "--timeout timeout\n     Request timeout (default=30s)") {
+		t.Errorf("Expected duration default in usage, got %q", flags.Usage())
+	}
+
+	// Invalid durations are parse errors
+	flags = initalizeFlagSet()
+	flags.AddDurationFlag("timeout", "t", "Request timeout", 30*time.Second)
+	if err := flags.Parse("util", "--timeout", "soon"); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+	if !strings.Contains(flags.Usage(), "--timeout duration") {
+		t.Errorf("Expected duration type name in usage, got %q", flags.Usage())
+	}
+}
+
+func TestFlagSet_AddLinesFileFlag(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	os.WriteFile(first, []byte("# patterns\n*.log\n\n  tmp/  \n"), 0644)
+	os.WriteFile(second, []byte("*.bak\n"), 0644)
+
+	flags := initalizeFlagSet()
+	flags.AddLinesFileFlag("exclude-from", "X", "Read patterns from `file`")
+	if err := flags.Parse("util", "--exclude-from", first, "-X", second); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	got, err := flags.GetStringSlice("exclude-from")
+	if err != nil {
+		t.Fatalf("Could not get flag exclude-from: %v", err)
+	}
+	expect := []string{"*.log", "tmp/", "*.bak"}
+	if strings.Join(got, " ") != strings.Join(expect, " ") {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	// A missing file is a parse error
+	flags = initalizeFlagSet()
+	flags.AddLinesFileFlag("exclude-from", "X", "Read patterns from `file`")
+	if err := flags.Parse("util", "-X", filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestFlagSet_AddUintFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddUintFlag("port", "p", "Listen `port`", 8080)
+	if err := flags.Parse("util", "--port", "443"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	got, err := flags.GetUint("port")
+	if err != nil {
+		t.Fatalf("Could not get flag port: %v", err)
+	}
+	if got != 443 {
+		t.Errorf("Expected %v, got %v", 443, got)
+	}
+	if _, err := flags.GetInt("port"); err == nil {
+		t.Error("Expected incorrect flag type error from GetInt")
+	}
+	if !strings.Contains(flags.Usage(), "(default=8080)") {
+		t.Errorf("Expected uint default in usage, got %q", flags.Usage())
+	}
+
+	// Negative values are parse errors
+	flags = initalizeFlagSet()
+	flags.AddUintFlag("port", "p", "Listen port", 8080)
+	if err := flags.Parse("util", "--port", "-5"); err == nil {
+		t.Error("Expected error for negative value")
+	}
+	if !strings.Contains(flags.Usage(), "--port uint") {
+		t.Errorf("Expected uint type name in usage, got %q", flags.Usage())
+	}
+	if _, err := flags.GetUint("missing"); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_ZeroValue(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddFlag("help", "h", "Help")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddFloatFlag("skew", "s", "Skew `percentage`", 2.5)
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.AddDurationFlag("timeout", "t", "Timeout", time.Second)
+
+	tests := []struct {
+		key    string
+		expect interface{}
+	}{
+		{"help", false},
+		{"line", int64(0)},
+		{"skew", float64(0)},
+		{"output", ""},
+		{"timeout", time.Duration(0)},
+	}
+	for _, tc := range tests {
+		got, err := flags.ZeroValue(tc.key)
+		if err != nil {
+			t.Fatalf("Could not get zero value of %q: %v", tc.key, err)
+		}
+		if got != tc.expect {
+			t.Errorf("Key %q: expected %#v, got %#v", tc.key, tc.expect, got)
+		}
+	}
+
+	if _, err := flags.ZeroValue("missing"); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_AddStringSliceFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringSliceFlag("include", "I", "Include `directory`", []string{"/usr/include"})
+	if !strings.Contains(flags.Usage(), "(default=/usr/include)") {
+		t.Errorf("Expected slice default in usage, got %q", flags.Usage())
+	}
+
+	// Long and short forms intermixed keep command line order
+	if err := flags.Parse("util", "-I", "a", "--include", "b", "-I", "c", "file"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	got, err := flags.GetStringSlice("include")
+	if err != nil {
+		t.Fatalf("Could not get flag include: %v", err)
+	}
+	if strings.Join(got, " ") != "a b c" {
+		t.Errorf("Expected [a b c], got %q", got)
+	}
+	if args := flags.GetArgs(); len(args) != 1 || args[0] != "file" {
+		t.Errorf("Expected args [file], got %q", args)
+	}
+
+	// The default applies when the flag is not used
+	flags = initalizeFlagSet()
+	flags.AddStringSliceFlag("include", "I", "Include `directory`", []string{"/usr/include"})
+	flags.Parse("util")
+	got, _ = flags.GetStringSlice("include")
+	if strings.Join(got, " ") != "/usr/include" {
+		t.Errorf("Expected [/usr/include], got %q", got)
+	}
+}
+
+func TestFlagSet_SetRequired(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("config", "c", "Config `file`", "")
+	flags.AddIntFlag("line", "l", "Line Number", 0)
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	for _, key := range []string{"config", "line", "output"} {
+		if err := flags.SetRequired(key); err != nil {
+			t.Fatalf("Could not mark %q required: %v", key, err)
+		}
+	}
+
+	// Both missing flags are named; output is satisfied by its default
+	err := flags.Parse("util")
+	if err == nil {
+		t.Fatal("Expected error for missing required flags")
+	}
+	expect := `missing required flags: "config", "line"`
+	if err.Error() != expect {
+		t.Errorf("Expected %q, got %q", expect, err)
+	}
+
+	flags = initalizeFlagSet()
+	flags.AddStringFlag("config", "c", "Config `file`", "")
+	flags.SetRequired("config")
+	if err := flags.Parse("util", "-c", "app.yml"); err != nil {
+		t.Errorf("Could not parse: %v", err)
+	}
+
+	if err := flags.SetRequired("missing"); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_AddGlobFlag(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddGlobFlag("pattern", "p", "Files to match", "*")
+	if err := flags.Parse("util", "--pattern", "*.log"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	got, err := flags.GetString("pattern")
+	if err != nil {
+		t.Fatalf("Could not get flag pattern: %v", err)
+	}
+	if got != "*.log" {
+		t.Errorf("Expected %q, got %q", "*.log", got)
+	}
+	if !strings.Contains(flags.Usage(), "--pattern glob") {
+		t.Errorf("Expected glob placeholder in usage, got %q", flags.Usage())
+	}
+
+	// Bad bracket expression
+	flags = initalizeFlagSet()
+	flags.AddGlobFlag("pattern", "p", "Files to match", "*")
+	if err := flags.Parse("util", "--pattern", "[a-"); err == nil {
+		t.Error("Expected error for malformed glob")
+	}
+}
+
+func TestFlagSet_SetUsageStyle(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("config", "c", "Config `file`", "")
+	flags.AddFlag("help", "h", "Help")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.SetRequired("config")
+
+	if err := flags.SetUsageStyle(UsageStyleRequiredFirst); err != nil {
+		t.Fatalf("Could not set usage style: %v", err)
+	}
+
+	expect := "\nRequired:\n  -c, --config file\n     Config file" +
+		"\nOptional:\n  -h, --help \n     Help\n  -l, --line int\n     Line Number (default=1)"
+	if !strings.HasSuffix(flags.Usage(), expect) {
+		t.Errorf("Expected usage to end with %q, got %q", expect, flags.Usage())
+	}
+
+	if err := flags.SetUsageStyle("fancy"); err == nil {
+		t.Error("Expected error for unknown usage style")
+	}
+}
+
+func TestFlagSet_Lookup(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "-l", "Line Number", 1)
+
+	f, ok := flags.Lookup("line")
+	if !ok {
+		t.Fatal("Expected flag line to exist")
+	}
+	if f.Key() != "line" || f.ShortName() != "l" || f.Type() != INT || f.Usage() != "Line Number" {
+		t.Errorf("Unexpected flag metadata %v", f)
+	}
+
+	if _, ok := flags.Lookup("missing"); ok {
+		t.Error("Expected flag missing not to exist")
+	}
+}
+
+func TestFlagSet_VisitAll(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "")
+	flags.AddFlag("help", "h", "Help")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.Parse("util", "-o", "/tmp", "--line=1")
+
+	var all, set []string
+	flags.VisitAll(func(f *Flag) { all = append(all, f.Key()) })
+	flags.Visit(func(f *Flag) { set = append(set, f.Key()) })
+
+	if strings.Join(all, " ") != "help line output" {
+		t.Errorf("Expected [help line output], got %q", all)
+	}
+	if strings.Join(set, " ") != "line output" {
+		t.Errorf("Expected [line output], got %q", set)
+	}
+}
+
+func TestFlagSet_SetAllowReparse(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	if err := flags.Parse("util"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if err := flags.Parse("util", "-l", "2"); err == nil {
+		t.Error("Expected error parsing twice")
+	}
+
+	flags.SetAllowReparse(true)
+	if err := flags.Parse("util", "-l", "2"); err != nil {
+		t.Errorf("Could not reparse: %v", err)
+	}
+	got, _ := flags.GetInt("line")
+	if got != 2 {
+		t.Errorf("Expected %v, got %v", 2, got)
+	}
+
+	// Only the flags of the latest Parse count as set
+	flags = initalizeFlagSet()
+	flags.SetAllowReparse(true)
+	flags.AddFlag("verbose", "v", "Verbose output")
+	flags.AddStringSliceFlag("include", "I", "Include `directory`", nil)
+	if err := flags.Parse("util", "-v", "-I", "a"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if err := flags.Parse("util", "-I", "b"); err != nil {
+		t.Fatalf("Could not reparse: %v", err)
+	}
+	if flags.WasSet("verbose") {
+		t.Error("Expected verbose not to be set by the reparse")
+	}
+	if include, _ := flags.GetStringSlice("include"); len(include) != 1 || include[0] != "b" {
+		t.Errorf("Expected %q, got %q", []string{"b"}, include)
+	}
+
+	// Simulated arguments count as set by the next Parse only
+	flags.SimulateArg("verbose", "true")
+	if err := flags.Parse("util"); err != nil {
+		t.Fatalf("Could not reparse: %v", err)
+	}
+	if !flags.WasSet("verbose") {
+		t.Error("Expected simulated verbose to be set")
+	}
+	if err := flags.Parse("util"); err != nil {
+		t.Fatalf("Could not reparse: %v", err)
+	}
+	if flags.WasSet("verbose") {
+		t.Error("Expected verbose not to be set by the reparse")
+	}
+
+	// A failed Parse does not count as a parse
+	flags = initalizeFlagSet()
+	flags.SetOutput(new(bytes.Buffer))
+	flags.AddIntFlag("line", "l", "Line Number", 0)
+	flags.SetRequired("line")
+	if err := flags.Parse("util"); err == nil {
+		t.Error("Expected error for missing required flag")
+	}
+	if err := flags.Parse("util", "-l", "2"); err != nil {
+		t.Errorf("Could not parse after a failed parse: %v", err)
+	}
+}
+
+func TestFlagSet_BindEnv(t *testing.T) {
+	os.Setenv("APP_OUTPUT", "/data")
+	os.Setenv("APP_LINE", "12")
+	os.Setenv("BAD_LINE", "twelve")
+	defer os.Unsetenv("APP_OUTPUT")
+	defer os.Unsetenv("APP_LINE")
+	defer os.Unsetenv("BAD_LINE")
+
+	// Env wins over defaults, command line wins over env
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddFloatFlag("skew", "s", "Skew `percentage`", 2.5)
+	flags.SetEnvPrefix("APP")
+	flags.BindEnv("output", "")
+	flags.BindEnv("line", "")
+	flags.BindEnv("skew", "")
+	if err := flags.Parse("util", "-l", "3"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	output, _ := flags.GetString("output")
+	if output != "/data" {
+		t.Errorf("Expected %q, got %q", "/data", output)
+	}
+	line, _ := flags.GetInt("line")
+	if line != 3 {
+		t.Errorf("Expected %v, got %v", 3, line)
+	}
+	skew, _ := flags.GetFloat("skew")
+	if skew != 2.5 {
+		t.Errorf("Expected %v, got %v", 2.5, skew)
+	}
+
+	// A required flag is satisfied by its environment variable
+	flags = initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "")
+	flags.SetRequired("output")
+	flags.BindEnv("output", "APP_OUTPUT")
+	if err := flags.Parse("util"); err != nil {
+		t.Errorf("Could not parse: %v", err)
+	}
+
+	// Conversion errors name the variable
+	flags = initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.BindEnv("line", "BAD_LINE")
+	err := flags.Parse("util")
+	if err == nil || !strings.Contains(err.Error(), "BAD_LINE") {
+		t.Errorf("Expected error naming BAD_LINE, got %v", err)
+	}
+
+	if err := flags.BindEnv("missing", ""); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_AddSignedIntFlag(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect int64
+	}{
+		{[]string{"util", "--offset", "+5"}, 5},
+		{[]string{"util", "--offset", "-3", "file"}, -3},
+		{[]string{"util", "-d=-3"}, -3},
+		{[]string{"util", "--", "-3"}, 0},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddSignedIntFlag("offset", "d", "Relative `adjustment`", 0)
+		if err := flags.Parse(tc.args...); err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.args, err)
+		}
+
+		got, err := flags.GetInt("offset")
+		if err != nil {
+			t.Fatalf("Could not get flag offset: %v", err)
+		}
+		if got != tc.expect {
+			t.Errorf("Args %q: expected %v, got %v", tc.args, tc.expect, got)
+		}
+	}
+
+	flags := initalizeFlagSet()
+	flags.AddSignedIntFlag("offset", "d", "Relative `adjustment`", 0)
+	if err := flags.Parse("util", "--offset", "+-3"); err == nil {
+		t.Error("Expected error for malformed sign")
+	}
+}
+
+func TestFlagSet_WithOutput(t *testing.T) {
+	var orig, buf bytes.Buffer
+	flags := initalizeFlagSet()
+	flags.SetOutput(&orig)
+
+	flags.WithOutput(&buf, func() {
+		if flags.Output() != &buf {
+			t.Error("Expected output to be the buffer inside fn")
+		}
+		flags.Parse("util", "--unknown")
+	})
+
+	if !strings.Contains(buf.String(), "-unknown") {
+		t.Errorf("Expected parse error in buffer, got %q", buf.String())
+	}
+	if flags.Output() != &orig {
+		t.Error("Expected output to be restored after fn")
+	}
+
+	// Restored even if fn panics
+	func() {
+		defer func() { recover() }()
+		flags.WithOutput(&buf, func() { panic("boom") })
+	}()
+	if flags.Output() != &orig {
+		t.Error("Expected output to be restored after panic")
+	}
+}
+
+func TestFlagSet_Parse_ValueForms(t *testing.T) {
+	tests := []struct {
+		args   []string
+		key    string
+		expect string
+	}{
+		{[]string{"--help=true"}, "help", "true"},
+		{[]string{"-h=true"}, "help", "true"},
+		{[]string{"--help"}, "help", "true"},
+		{[]string{"--help=false"}, "help", "false"},
+		{[]string{"--verbose=true"}, "verbose", "true"},
+		{[]string{"-v=false"}, "verbose", "false"},
+		{[]string{"-v"}, "verbose", "true"},
+		{[]string{"--line=4"}, "line", "4"},
+		{[]string{"-l=4"}, "line", "4"},
+		{[]string{"--line", "4"}, "line", "4"},
+		{[]string{"--skew=1.5"}, "skew", "1.5"},
+		{[]string{"-s=1.5"}, "skew", "1.5"},
+		{[]string{"--skew", "1.5"}, "skew", "1.5"},
+		{[]string{"--output=/tmp"}, "output", "/tmp"},
+		{[]string{"-o=/tmp"}, "output", "/tmp"},
+		{[]string{"--output", "/tmp"}, "output", "/tmp"},
+		{[]string{"--output=a=b"}, "output", "a=b"},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddFlag("help", "h", "Help")
+		flags.AddBoolFlag("verbose", "v", "Verbose output", true)
+		flags.AddIntFlag("line", "l", "Line Number", 1)
+		flags.AddFloatFlag("skew", "s", "Skew `percentage`", 2.5)
+		flags.AddStringFlag("output", "o", "Output `directory`", "")
+		if err := flags.Parse(append([]string{"util"}, tc.args...)...); err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.args, err)
+		}
+
+		got, _ := flags.GetAsString(tc.key)
+		if got != tc.expect {
+			t.Errorf("Args %q: expected %q, got %q", tc.args, tc.expect, got)
+		}
+	}
+
+	// A bool flag does not consume the following argument
+	flags := initalizeFlagSet()
+	flags.AddFlag("help", "h", "Help")
+	flags.Parse("util", "--help", "false")
+	if args := flags.GetArgs(); len(args) != 1 || args[0] != "false" {
+		t.Errorf("Expected args [false], got %q", args)
+	}
+}
+
+func TestGet(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddDurationFlag("timeout", "t", "Timeout", time.Second)
+
+	if _, err := Get[string](flags, "output"); err == nil {
+		t.Error("Expected error before parse")
+	}
+	flags.Parse("util", "-l", "7")
+
+	output, err := Get[string](flags, "output")
+	if err != nil || output != "/var/log/output" {
+		t.Errorf("Expected %q, got %q (%v)", "/var/log/output", output, err)
+	}
+	line, err := Get[int64](flags, "line")
+	if err != nil || line != 7 {
+		t.Errorf("Expected %v, got %v (%v)", 7, line, err)
+	}
+	timeout, err := Get[time.Duration](flags, "timeout")
+	if err != nil || timeout != time.Second {
+		t.Errorf("Expected %v, got %v (%v)", time.Second, timeout, err)
+	}
+
+	// Type mismatches return an error
+	if _, err := Get[int64](flags, "output"); err == nil {
+		t.Error("Expected error reading a STRING flag as int64")
+	}
+	if _, err := Get[int](flags, "line"); err == nil {
+		t.Error("Expected error reading an INT flag as int")
+	}
+	if _, err := Get[string](flags, "missing"); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_AddQuantityFlag(t *testing.T) {
+	units := map[string]float64{"s": 1, "m": 60, "ms": 0.001}
+	tests := []struct {
+		arg    string
+		expect float64
+		fail   bool
+	}{
+		{"5m", 300, false},
+		{"300s", 300, false},
+		{"1500ms", 1.5, false},
+		{"42", 42, false},
+		{"5h", 0, true},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddQuantityFlag("interval", "i", "Polling interval", units, "s", 60)
+		err := flags.Parse("util", "--interval", tc.arg)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tc.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.arg, err)
+		}
+
+		got, err := flags.GetFloat("interval")
+		if err != nil {
+			t.Fatalf("Could not get flag interval: %v", err)
+		}
+		if got != tc.expect {
+			t.Errorf("Arg %q: expected %v, got %v", tc.arg, tc.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_MustGetString(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output `directory`", "/var/log/output")
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddFloatFlag("skew", "s", "Skew `percentage`", 2.5)
+	flags.AddBoolFlag("verbose", "v", "Verbose output", false)
+	flags.AddFlag("help", "h", "Help")
+	flags.Parse("util", "-v")
+
+	if flags.MustGetString("output") != "/var/log/output" || flags.MustGetInt("line") != 1 ||
+		flags.MustGetFloat("skew") != 2.5 || !flags.MustGetBool("verbose") || flags.MustGet("help") {
+		t.Error("Unexpected MustGet values")
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("Expected panic for mistyped flag")
+		}
+		msg := fmt.Sprint(r)
+		if !strings.Contains(msg, `"line"`) || !strings.Contains(msg, "incorrect flag type") {
+			t.Errorf("Expected key and reason in panic, got %q", msg)
+		}
+	}()
+	flags.MustGetString("line")
+}
+
+func TestFlagSet_AddIntFileFlag(t *testing.T) {
+	dir := t.TempDir()
+	pidsMax := filepath.Join(dir, "pids.max")
+	bad := filepath.Join(dir, "bad")
+	os.WriteFile(pidsMax, []byte("4096\n"), 0644)
+	os.WriteFile(bad, []byte("max\n"), 0644)
+
+	tests := []struct {
+		arg    string
+		expect int64
+		fail   bool
+	}{
+		{"128", 128, false},
+		{"@" + pidsMax, 4096, false},
+		{"@" + bad, 0, true},
+		{"@" + filepath.Join(dir, "missing"), 0, true},
+	}
+
+	for _, tc := range tests {
+		flags := initalizeFlagSet()
+		flags.AddIntFileFlag("max-pids", "p", "Process limit", 64)
+		err := flags.Parse("util", "--max-pids", tc.arg)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", tc.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse %q: %v", tc.arg, err)
+		}
+
+		got, _ := flags.GetInt("max-pids")
+		if got != tc.expect {
+			t.Errorf("Arg %q: expected %v, got %v", tc.arg, tc.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_Parse_KeepsOSArgs(t *testing.T) {
+	before := append([]string(nil), os.Args...)
+
+	flags := initalizeFlagSet()
+	flags.AddFlag("x", "", "Extra")
+	if err := flags.Parse("util", "-x"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	if strings.Join(os.Args, " ") != strings.Join(before, " ") {
+		t.Errorf("Expected os.Args %q to be unchanged, got %q", before, os.Args)
+	}
+	if v, _ := flags.Get("x"); !v {
+		t.Error("Expected x to be set")
+	}
+}
+
+func TestFlagSet_PrecedenceChain(t *testing.T) {
+	os.Setenv("APP_LINE", "5")
+	defer os.Unsetenv("APP_LINE")
+
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.SetEnvPrefix("APP")
+	flags.BindEnv("line", "")
+	if err := flags.Parse("util", "-l", "9"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	chain, err := flags.PrecedenceChain("line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []SourceValue{
+		{SourceDefault, "1"},
+		{SourceEnv, "5"},
+		{SourceCLI, "9"},
+	}
+	if fmt.Sprint(chain) != fmt.Sprint(expect) {
+		t.Errorf("Expected %v, got %v", expect, chain)
+	}
+	if src, _ := flags.Source("line"); src != SourceCLI {
+		t.Errorf("Expected %q, got %q", SourceCLI, src)
+	}
+
+	// Env wins when the flag is not on the command line
+	flags = initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.SetEnvPrefix("APP")
+	flags.BindEnv("line", "")
+	flags.Parse("util")
+	if src, _ := flags.Source("line"); src != SourceEnv {
+		t.Errorf("Expected %q, got %q", SourceEnv, src)
+	}
+	if got, _ := flags.GetInt("line"); got != 5 {
+		t.Errorf("Expected %d, got %d", 5, got)
+	}
+}
+
+func TestFlagSet_MarkRequires(t *testing.T) {
+	setup := func() *FlagSet {
+		flags := initalizeFlagSet()
+		flags.AddStringFlag("tls-cert", "c", "Certificate file", "")
+		flags.AddStringFlag("tls-key", "k", "Key file", "")
+		flags.AddFlag("tls", "t", "Enable TLS")
+		if err := flags.MarkRequires("tls", "tls-cert", "tls-key"); err != nil {
+			t.Fatal(err)
+		}
+		return flags
+	}
+
+	tests := []struct {
+		args   []string
+		expect string
+	}{
+		// Satisfied chain
+		{[]string{"util", "-t", "-c", "a.pem", "-k", "a.key"}, ""},
+		// Dependencies alone are fine
+		{[]string{"util", "-c", "a.pem"}, ""},
+		// Dependent flag left at its default
+		{[]string{"util"}, ""},
+		// Unsatisfied chain
+		{[]string{"util", "-t", "-c", "a.pem"}, `"tls": must be used with "tls-key"`},
+		{[]string{"util", "--tls"}, `"tls": must be used with "tls-cert", "tls-key"`},
+	}
+	for _, test := range tests {
+		err := setup().Parse(test.args...)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.expect {
+			t.Errorf("%v: Expected %q, got %q", test.args, test.expect, got)
+		}
+	}
+
+	if err := setup().MarkRequires("tls", "tls-ca"); err == nil {
+		t.Error("Expected error for unknown dependency")
+	}
+}
+
+func TestFlagSet_EnableHelp(t *testing.T) {
+	var out bytes.Buffer
+	flags := initalizeFlagSet()
+	flags.SetOutput(&out)
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.SetRequired("output")
+	if err := flags.EnableHelp(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Help wins over missing required flags
+	err := flags.Parse("util", "-h")
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("Expected ErrHelp, got %v", err)
+	}
+	if out.String() != flags.Usage()+"\n" {
+		t.Errorf("Expected %q, got %q", flags.Usage()+"\n", out.String())
+	}
+
+	if err := flags.EnableHelp(); err == nil {
+		t.Error("Expected error enabling help twice")
+	}
+}
+
+func TestFlagSet_EnableVersion(t *testing.T) {
+	var out bytes.Buffer
+	flags := initalizeFlagSet()
+	flags.SetOutput(&out)
+	flags.AddFlag("verbose", "v", "Verbose output")
+	if err := flags.EnableVersion("util 1.4.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flags.Parse("util", "-V"); !errors.Is(err, ErrVersion) {
+		t.Fatalf("Expected ErrVersion, got %v", err)
+	}
+	if out.String() != "util 1.4.2\n" {
+		t.Errorf("Expected %q, got %q", "util 1.4.2\n", out.String())
+	}
+
+	// A taken short name leaves only the long form
+	flags = initalizeFlagSet()
+	flags.AddFlag("verbose", "V", "Verbose output")
+	if err := flags.EnableVersion("util 1.4.2"); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := flags.Lookup("version"); f.ShortName() != "" {
+		t.Errorf("Expected no short name, got %q", f.ShortName())
+	}
+	if !strings.Contains(flags.Usage(), "\n  --version \n") {
+		t.Errorf("Expected long form only in usage, got %q", flags.Usage())
+	}
+}
+
+func TestFlagSet_SetUsageWidth(t *testing.T) {
+	usage := "Write the report to `file`, creating it if it does not exist and truncating it otherwise"
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", usage, "")
+	flags.SetUsageWidth(40)
+
+	expect := "\nOptions:\n  -o, --output file\n" +
+		"     Write the report to file, creating\n" +
+		"     it if it does not exist and\n" +
+		"     truncating it otherwise"
+	if got := flags.Usage(); !strings.HasSuffix(got, expect) {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+
+	// Zero disables wrapping
+	flags.SetUsageWidth(0)
+	expect = "\n  -o, --output file\n     Write the report to file, creating it if it does not exist and truncating it otherwise"
+	if got := flags.Usage(); !strings.HasSuffix(got, expect) {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+func TestFlagSet_SetGroup(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.AddIntFlag("port", "p", "Port", 80)
+	flags.AddStringFlag("host", "H", "Host", "")
+	flags.AddFlag("debug", "d", "Debug logging")
+	before := flags.Usage()
+
+	// Without groups nothing changes
+	for _, key := range []string{"output", "port"} {
+		flags.SetGroup(key, "")
+	}
+	if flags.Usage() != before {
+		t.Errorf("Expected %q, got %q", before, flags.Usage())
+	}
+
+	flags.SetGroup("port", "Network")
+	flags.SetGroup("host", "Network")
+	flags.SetGroup("debug", "Logging")
+	expect := "\nOptions:\n  -o, --output string\n     Output file" +
+		"\nLogging:\n  -d, --debug \n     Debug logging" +
+		"\nNetwork:\n  -H, --host string\n     Host\n  -p, --port int\n     Port (default=80)"
+	if usage := flags.Usage(); !strings.HasSuffix(usage, expect) {
+		t.Errorf("Expected %q, got %q", expect, usage)
+	}
+
+	if err := flags.SetGroup("missing", "Network"); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_MarkHidden(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddFlag("trace-internals", "T", "Dump parser state")
+	if err := flags.MarkHidden("trace-internals"); err != nil {
+		t.Fatal(err)
+	}
+
+	usage := flags.Usage()
+	if strings.Contains(usage, "trace-internals") || strings.Contains(usage, "T") {
+		t.Errorf("Expected hidden flag to be left out of usage, got %q", usage)
+	}
+	if !strings.Contains(usage, "--line") {
+		t.Errorf("Expected visible flag in usage, got %q", usage)
+	}
+
+	if err := flags.Parse("util", "-T"); err != nil {
+		t.Fatalf("Could not parse hidden flag: %v", err)
+	}
+	if trace, _ := flags.Get("trace-internals"); !trace {
+		t.Error("Expected hidden flag to be set")
+	}
+}
+
+func TestFlagSet_Parsed(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	if flags.Parsed() {
+		t.Error("Expected FlagSet not to be parsed")
+	}
+	flags.Parse("util")
+	if !flags.Parsed() {
+		t.Error("Expected FlagSet to be parsed")
+	}
+}
+
+func TestFlagSet_Parse_Failed(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.SetOutput(new(bytes.Buffer))
+	flags.AddEnumFlag("mode", "m", "Run mode", []string{"fast", "safe"}, "fast")
+	flags.AddIntFlagRange("line", "l", "Line Number", 1, 1, 10)
+	flags.AddStringFlag("output", "o", "Output file", "")
+	flags.SetValidator("output", func(v interface{}) error {
+		if v.(string) == "bad" {
+			return fmt.Errorf("bad output")
+		}
+		return nil
+	})
+
+	// A failed Parse leaves the FlagSet unparsed and unchanged
+	for _, args := range [][]string{
+		{"util", "-l", "5", "--mode", "bogus"},
+		{"util", "-m", "safe", "--line", "50"},
+		{"util", "-m", "safe", "--output", "bad"},
+		{"util", "-m", "safe", "--nope"},
+	} {
+		if err := flags.Parse(args...); err == nil {
+			t.Errorf("%q: Expected error", args)
+		}
+		if flags.Parsed() {
+			t.Errorf("%q: Expected FlagSet not to be parsed", args)
+		}
+		if _, err := flags.GetString("mode"); err == nil {
+			t.Errorf("%q: Expected error getting a flag of a failed parse", args)
+		}
+	}
+
+	// A retry starts afresh
+	if err := flags.Parse("util", "-o", "out"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if mode, _ := flags.GetString("mode"); mode != "fast" {
+		t.Errorf("Expected %q, got %q", "fast", mode)
+	}
+	if line, _ := flags.GetInt("line"); line != 1 {
+		t.Errorf("Expected %d, got %d", 1, line)
+	}
+	for _, key := range []string{"mode", "line"} {
+		if flags.WasSet(key) {
+			t.Errorf("Expected %q not to be set", key)
+		}
+	}
+}
+
+func TestFlagSet_ParseArgs(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	if err := flags.ParseArgs([]string{"-l", "7", "file"}); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	if line, _ := flags.GetInt("line"); line != 7 {
+		t.Errorf("Expected %d, got %d", 7, line)
+	}
+	if args := flags.GetArgs(); len(args) != 1 || args[0] != "file" {
+		t.Errorf("Expected %q, got %q", []string{"file"}, args)
+	}
+}
+
+func TestFlagSet_Parse_ParseError(t *testing.T) {
+	tests := []struct {
+		args []string
+		flag string
+	}{
+		{[]string{"--unknown"}, "unknown"},
+		{[]string{"--line", "ten"}, "line"},
+		{[]string{"-v=maybe"}, "v"},
+		{[]string{"-l"}, "l"},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.SetOutput(new(bytes.Buffer))
+		flags.AddIntFlag("line", "l", "Line Number", 1)
+		flags.AddFlag("verbose", "v", "Verbose output")
+
+		err := flags.Parse(append([]string{"util"}, test.args...)...)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%v: Expected ParseError, got %v", test.args, err)
+		}
+		if perr.FlagSet != "util" || perr.Flag != test.flag {
+			t.Errorf("%v: Expected flag %q, got %q", test.args, test.flag, perr.Flag)
+		}
+		if errors.Unwrap(err) == nil {
+			t.Errorf("%v: Expected core error", test.args)
+		}
+	}
+}
+
+func TestFlagSet_SetErrorHandling(t *testing.T) {
+	var out bytes.Buffer
+	flags := initalizeFlagSet()
+	flags.SetOutput(&out)
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+
+	// The default returns rather than exits
+	if err := flags.Parse("util", "--bogus"); err == nil {
+		t.Fatal("Expected error for unknown flag")
+	}
+	if !strings.Contains(out.String(), flags.Usage()) {
+		t.Errorf("Expected usage in output, got %q", out.String())
+	}
+
+	flags = initalizeFlagSet()
+	flags.SetOutput(new(bytes.Buffer))
+	flags.SetErrorHandling(flag.PanicOnError)
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on error")
+		}
+	}()
+	flags.Parse("util", "--bogus")
+}
+
+func TestFlagSet_Reset(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddStringSliceFlag("tag", "t", "Tags", []string{"base"})
+	flags.AddFlag("verbose", "v", "Verbose output")
+	if err := flags.Parse("util", "-l", "5", "-t", "a", "-v", "old"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	flags.Reset()
+	if _, err := flags.GetInt("line"); err == nil {
+		t.Error("Expected FlagSet to be unparsed after Reset")
+	}
+	if err := flags.Parse("util", "-t", "b", "new"); err != nil {
+		t.Fatalf("Could not parse after Reset: %v", err)
+	}
+
+	if line, _ := flags.GetInt("line"); line != 1 {
+		t.Errorf("Expected %d, got %d", 1, line)
+	}
+	if verbose, _ := flags.Get("verbose"); verbose {
+		t.Error("Expected verbose to be reset")
+	}
+	if tags, _ := flags.GetStringSlice("tag"); strings.Join(tags, ",") != "b" {
+		t.Errorf("Expected %q, got %q", "b", tags)
+	}
+	if args := flags.GetArgs(); len(args) != 1 || args[0] != "new" {
+		t.Errorf("Expected %q, got %q", []string{"new"}, args)
+	}
+	if set := flags.SetToDefault(); len(set) != 0 {
+		t.Errorf("Expected no flags set to default, got %q", set)
+	}
+}
+
+func TestFlagSet_ConcurrentAccess(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("output", "o", "Output file", "")
+	if err := flags.Parse("util", "-o", "out.txt"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+
+	base := initalizeFlagSet()
+	base.AddStringFlag("output", "o", "Output file", "base.txt")
+	base.Parse("util")
+	snap := flags.Snapshot()
+
+	// Readers see one of the values the writers set, never a torn one
+	valid := map[string]bool{"": true, "out.txt": true, "set.txt": true, "sim.txt": true, "base.txt": true}
+	done := make(chan struct{})
+	var wg, started sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if got, err := flags.GetString("output"); err == nil && !valid[got] {
+					t.Errorf("Unexpected value %q", got)
+					return
+				}
+				flags.Values()
+				flags.GetAsString("output")
+				flags.PrecedenceChain("output")
+				flags.Snapshot()
+				flags.SetToDefault()
+				flags.ZeroValue("output")
+				flags.Visit(func(f *Flag) { flags.GetString(f.Key()) })
+				flags.VisitAll(func(f *Flag) { flags.GetString(f.Key()) })
+			}
+		}()
+	}
+
+	// Write while the readers run
+	started.Wait()
+	for j := 0; j < 100; j++ {
+		flags.Set("output", "set.txt")
+		flags.SimulateArg("output", "sim.txt")
+		flags.RestoreSnapshot(snap)
+		flags.InheritValues(base)
+		flags.InheritValues(flags)
+		flags.Reset()
+		if err := flags.Parse("util", "-o", "out.txt"); err != nil {
+			t.Errorf("Could not parse: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestFlagSet_SetValidator_ReadsFlags(t *testing.T) {
+	setup := func() *FlagSet {
+		flags := initalizeFlagSet()
+		flags.AddBoolFlag("tls", "t", "Serve over TLS", false)
+		flags.AddStringFlag("cert", "c", "Certificate file", "")
+		flags.SetValidator("tls", func(v interface{}) error {
+			if cert, _ := flags.GetString("cert"); v.(bool) && cert == "" {
+				return fmt.Errorf("--cert is required with --tls")
+			}
+			return nil
+		})
+		return flags
+	}
+
+	tests := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"--tls", "--cert", "server.pem"}, ""},
+		{[]string{"--tls"}, `"tls": --cert is required with --tls`},
+		{[]string{}, ""},
+	}
+	for _, test := range tests {
+		flags := setup()
+		result := make(chan error, 1)
+		go func() {
+			result <- flags.Parse(append([]string{"util"}, test.args...)...)
+		}()
+
+		select {
+		case err := <-result:
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != test.expect {
+				t.Errorf("%v: Expected %q, got %q", test.args, test.expect, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: Deadlock running a validator that reads a flag", test.args)
+		}
+	}
+}
+
+func TestFlagSet_CallbacksReadFlags(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddStringFlag("region", "r", "Region", "eu")
+	flags.AddStringChoiceFuncFlag("zone", "z", "Zone", "", func() []string {
+		region, _ := flags.GetString("region")
+		return []string{region + "-1", region + "-2"}
+	})
+	flags.SetDisplayFunc("zone", func(v interface{}) string {
+		region, _ := flags.GetString("region")
+		return fmt.Sprintf("%s (in %s)", v, region)
+	})
+
+	// Fail rather than hang if a callback runs with the lock held
+	result := make(chan error, 1)
+	go func() {
+		if err := flags.Parse("util", "-r", "us", "-z", "us-2"); err != nil {
+			result <- err
+			return
+		}
+		if got, _ := flags.GetAsString("zone"); got != "us-2 (in us)" {
+			result <- fmt.Errorf("Expected %q, got %q", "us-2 (in us)", got)
+			return
+		}
+		chain, _ := flags.PrecedenceChain("zone")
+		if len(chain) != 2 || chain[1].Value != "us-2 (in us)" {
+			result <- fmt.Errorf("Expected display value in chain, got %v", chain)
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Deadlock running a callback that reads a flag")
+	}
+}
+
+func TestFlagSet_SetDefaultArgs(t *testing.T) {
+	setup := func() *FlagSet {
+		flags := initalizeFlagSet()
+		flags.AddFlag("list", "l", "List entries")
+		flags.AddIntFlag("depth", "d", "Depth", 1)
+		flags.SetDefaultArgs("--list", "--depth", "2")
+		return flags
+	}
+
+	// No arguments applies the defaults
+	flags := setup()
+	if err := flags.Parse("util"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if list, _ := flags.Get("list"); !list {
+		t.Error("Expected default args to set list")
+	}
+	if depth, _ := flags.GetInt("depth"); depth != 2 {
+		t.Errorf("Expected %d, got %d", 2, depth)
+	}
+
+	// Explicit arguments replace the defaults
+	flags = setup()
+	if err := flags.Parse("util", "-d", "3"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	if list, _ := flags.Get("list"); list {
+		t.Error("Expected default args to be ignored")
+	}
+	if depth, _ := flags.GetInt("depth"); depth != 3 {
+		t.Errorf("Expected %d, got %d", 3, depth)
+	}
+}
+
+func TestFlagSet_Lint(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	if problems := flags.Lint(); problems != nil {
+		t.Errorf("Expected no problems, got %q", problems)
+	}
+
+	flags.AddStringFlag("output", "o", "", "")
+	flags.AddIntFlag("retries", "r", "Retry count", 3)
+	flags.SetRequired("retries")
+	flags.AddStringChoiceFuncFlag("codec", "c", "Codec", "lz4", func() []string {
+		return []string{"gzip", "zstd"}
+	})
+
+	expect := []string{
+		`"codec": default "lz4" is not one of [gzip|zstd]`,
+		`"output": missing usage`,
+		`"retries": required flag has default 3`,
+	}
+	got := flags.Lint()
+	if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
+
+func TestFlagSet_SetValidator(t *testing.T) {
+	email := func(v interface{}) error {
+		if !strings.Contains(v.(string), "@") {
+			return fmt.Errorf("%q is not an email address", v)
+		}
+		return nil
+	}
+	even := func(v interface{}) error {
+		if v.(int64)%2 != 0 {
+			return fmt.Errorf("%d is not even", v)
+		}
+		return nil
+	}
+
+	tests := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"-e", "a@example.com", "-n", "4"}, ""},
+		{[]string{"-e", "nobody", "-n", "4"}, `"email": "nobody" is not an email address`},
+		{[]string{"-e", "a@example.com", "-n", "3"}, `"count": 3 is not even`},
+		// Errors are reported in sorted order of key
+		{[]string{"-e", "nobody", "-n", "3"}, `"count": 3 is not even`},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddStringFlag("email", "e", "Contact address", "")
+		flags.AddIntFlag("count", "n", "Batch size", 2)
+		flags.SetValidator("email", email)
+		flags.SetValidator("count", even)
+
+		err := flags.Parse(append([]string{"util"}, test.args...)...)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.expect {
+			t.Errorf("%v: Expected %q, got %q", test.args, test.expect, got)
+		}
+	}
+}
+
+func TestFlagSet_Set(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddStringSliceFlag("tag", "t", "Tags", nil)
+	flags.Parse("util")
+
+	if err := flags.Set("line", int64(12)); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := flags.GetInt("line"); line != 12 {
+		t.Errorf("Expected %d, got %d", 12, line)
+	}
+	if err := flags.Set("tag", []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if tags, _ := flags.GetStringSlice("tag"); strings.Join(tags, ",") != "a,b" {
+		t.Errorf("Expected %q, got %q", "a,b", tags)
+	}
+
+	expect := `"line": cannot set INT flag to string, want int64`
+	if err := flags.Set("line", "12"); err == nil || err.Error() != expect {
+		t.Errorf("Expected %q, got %v", expect, err)
+	}
+	if err := flags.Set("missing", true); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+}
+
+func TestFlagSet_Values(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddFlag("verbose", "v", "Verbose output")
+	flags.AddBoolFlag("color", "c", "Colored output", true)
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddFloatFlag("ratio", "r", "Ratio", 0.5)
+	flags.AddStringFlag("output", "o", "Output file", "")
+
+	if values := flags.Values(); len(values) != 0 {
+		t.Errorf("Expected no values before parse, got %v", values)
+	}
+
+	flags.Parse("util", "-v", "-o", "out.txt")
+	values := flags.Values()
+	expect := map[string]interface{}{
+		"verbose": true,
+		"color":   true,
+		"line":    int64(1),
+		"ratio":   0.5,
+		"output":  "out.txt",
+	}
+	if len(values) != len(expect) {
+		t.Fatalf("Expected %v, got %v", expect, values)
+	}
+	for k, v := range expect {
+		if values[k] != v {
+			t.Errorf("%s: Expected %#v, got %#v", k, v, values[k])
+		}
+	}
+}
+
+func TestFlagSet_VerboseUsage(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddIntFlag("line", "l", "Line Number", 1)
+	flags.AddStringChoiceFuncFlag("mode", "m", "Run mode", "fast", func() []string {
+		return []string{"fast", "safe"}
+	})
+	flags.SetRequired("mode")
+	flags.SetEnvPrefix("APP")
+	flags.BindEnv("line", "")
+
+	expect := `Flags of "util":
+  -l, --line
+     Line Number
+     type: INT
+     default: 1
+     env: APP_LINE
+  -m, --mode
+     Run mode
+     type: STRING
+     default: fast
+     required
+     choices: fast|safe`
+	if got := flags.VerboseUsage(); got != expect {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/scu/flagplus
 
-go 1.15
+go 1.18
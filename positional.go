@@ -0,0 +1,172 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// positional is a named argument following the flags
+type positional struct {
+	name     string
+	usage    string
+	variadic bool // Does it take every remaining argument?
+}
+
+// String renders the positional for the usage synopsis
+func (p positional) String() string {
+	if p.variadic {
+		return p.name + "..."
+	}
+	return p.name
+}
+
+// AddPositional declares a named argument following the flags. Parse
+// assigns arguments to positionals in the order they are declared and
+// reports missing ones. Read the value with GetPositional.
+func (fs *FlagSet) AddPositional(name, usage string) error {
+	return fs.addPositional(name, usage, false)
+}
+
+// AddPositionalVariadic declares a named positional taking one or more
+// of the remaining arguments. It must be the last positional. Read the
+// values with GetPositionalVariadic.
+func (fs *FlagSet) AddPositionalVariadic(name, usage string) error {
+	return fs.addPositional(name, usage, true)
+}
+
+// addPositional appends a positional to the FlagSet
+func (fs *FlagSet) addPositional(name, usage string, variadic bool) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	for _, p := range fs.positionals {
+		if p.name == name {
+			return fmt.Errorf("%q: positional already exists", name)
+		}
+		if p.variadic {
+			return fmt.Errorf("%q: positional follows variadic positional %q", name, p.name)
+		}
+	}
+
+	fs.positionals = append(fs.positionals, positional{name, usage, variadic})
+	return nil
+}
+
+// SetArgRange sets how many arguments may follow the flags, from min
+// to max inclusive. A max of -1 is unlimited.
+func (fs *FlagSet) SetArgRange(min, max int) error {
+	if min < 0 || max < -1 || max != -1 && max < min {
+		return fmt.Errorf("invalid argument range [%d,%d]", min, max)
+	}
+
+	fs.argRange = []int{min, max}
+	return nil
+}
+
+// checkArgRange verifies the number of arguments after flags is within
+// the range set by SetArgRange
+func (fs *FlagSet) checkArgRange() error {
+	if fs.argRange == nil {
+		return nil
+	}
+
+	n, min, max := fs.coreFlagSet.NArg(), fs.argRange[0], fs.argRange[1]
+	switch {
+	case min == max && n != min:
+		return fmt.Errorf("expected %s, got %d", plural(min, "argument"), n)
+	case n < min:
+		return fmt.Errorf("expected at least %s, got %d", plural(min, "argument"), n)
+	case max != -1 && n > max:
+		return fmt.Errorf("expected at most %s, got %d", plural(max, "argument"), n)
+	}
+	return nil
+}
+
+// plural renders a count of a noun, adding "s" unless n is one
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// bindPositionals assigns the arguments after flags to the declared
+// positionals. Without declared positionals any arguments are allowed.
+func (fs *FlagSet) bindPositionals() error {
+	fs.posValues = nil
+	if len(fs.positionals) == 0 {
+		return nil
+	}
+
+	args := fs.coreFlagSet.Args()
+	fs.posValues = make(map[string][]string, len(fs.positionals))
+	var missing []string
+	for i, p := range fs.positionals {
+		switch {
+		case i >= len(args):
+			missing = append(missing, fmt.Sprintf("%q", p.name))
+		case p.variadic:
+			fs.posValues[p.name] = append([]string(nil), args[i:]...)
+		default:
+			fs.posValues[p.name] = []string{args[i]}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing arguments: %s", strings.Join(missing, ", "))
+	}
+
+	last := fs.positionals[len(fs.positionals)-1]
+	if !last.variadic && len(args) > len(fs.positionals) {
+		return fmt.Errorf("too many arguments, expected %d: %q",
+			len(fs.positionals), args[len(fs.positionals):])
+	}
+	return nil
+}
+
+// GetPositional returns the value of a positional added by
+// AddPositional
+func (fs *FlagSet) GetPositional(name string) (string, error) {
+	values, err := fs.positionalValues(name, false)
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// GetPositionalVariadic returns the values of a positional added by
+// AddPositionalVariadic
+func (fs *FlagSet) GetPositionalVariadic(name string) ([]string, error) {
+	values, err := fs.positionalValues(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), values...), nil
+}
+
+// positionalValues returns the values assigned to a positional
+func (fs *FlagSet) positionalValues(name string, variadic bool) ([]string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if !fs.isParsed {
+		return nil, fmt.Errorf("FlagSet %q has not been parsed", fs.name)
+	}
+	for _, p := range fs.positionals {
+		if p.name != name {
+			continue
+		}
+		if p.variadic != variadic {
+			return nil, fmt.Errorf("%q: incorrect positional kind", name)
+		}
+		values, ok := fs.posValues[name]
+		if !ok {
+			return nil, fmt.Errorf("%q: positional was not given", name)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%q: positional does not exist", name)
+}
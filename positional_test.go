@@ -0,0 +1,107 @@
+package flagplus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlagSet_AddPositional(t *testing.T) {
+	tests := []struct {
+		args   []string
+		src    string
+		dst    string
+		expect string
+	}{
+		{[]string{"a.txt", "b.txt"}, "a.txt", "b.txt", ""},
+		{[]string{"-v", "a.txt", "b.txt"}, "a.txt", "b.txt", ""},
+		{[]string{"a.txt"}, "", "", `missing arguments: "dst"`},
+		{[]string{}, "", "", `missing arguments: "src", "dst"`},
+		{[]string{"a.txt", "b.txt", "c.txt"}, "", "", `too many arguments, expected 2: ["c.txt"]`},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddFlag("verbose", "v", "Verbose output")
+		flags.AddPositional("src", "Source file")
+		flags.AddPositional("dst", "Destination file")
+
+		err := flags.Parse(append([]string{"util"}, test.args...)...)
+		if test.expect != "" {
+			if err == nil || err.Error() != test.expect {
+				t.Errorf("%v: Expected %q, got %v", test.args, test.expect, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: Could not parse: %v", test.args, err)
+		}
+		if src, _ := flags.GetPositional("src"); src != test.src {
+			t.Errorf("Expected %q, got %q", test.src, src)
+		}
+		if dst, _ := flags.GetPositional("dst"); dst != test.dst {
+			t.Errorf("Expected %q, got %q", test.dst, dst)
+		}
+	}
+}
+
+func TestFlagSet_AddPositionalVariadic(t *testing.T) {
+	flags := initalizeFlagSet()
+	flags.AddFlag("verbose", "v", "Verbose output")
+	flags.AddPositional("src", "Source file")
+	flags.AddPositionalVariadic("dst", "Destination files")
+	if err := flags.AddPositional("extra", "After variadic"); err == nil {
+		t.Error("Expected error for positional after variadic")
+	}
+
+	if !strings.Contains(flags.Usage(), "util [-v] src dst...\n") {
+		t.Errorf("Expected positionals in synopsis, got %q", flags.Usage())
+	}
+	if !strings.Contains(flags.Usage(), "\nArguments:\n  src\n     Source file\n  dst...\n     Destination files") {
+		t.Errorf("Expected arguments section, got %q", flags.Usage())
+	}
+
+	if err := flags.Parse("util", "a", "b", "c"); err != nil {
+		t.Fatalf("Could not parse: %v", err)
+	}
+	dst, err := flags.GetPositionalVariadic("dst")
+	if err != nil || strings.Join(dst, ",") != "b,c" {
+		t.Errorf("Expected %q, got %q (%v)", "b,c", dst, err)
+	}
+	if _, err := flags.GetPositional("dst"); err == nil {
+		t.Error("Expected error reading variadic positional as single")
+	}
+}
+
+func TestFlagSet_SetArgRange(t *testing.T) {
+	tests := []struct {
+		min, max int
+		args     []string
+		expect   string
+	}{
+		{1, 3, []string{"a"}, ""},
+		{1, 3, []string{"-v", "a", "b", "c"}, ""},
+		{1, 3, []string{"-v"}, "expected at least 1 argument, got 0"},
+		{1, 3, []string{"a", "b", "c", "d"}, "expected at most 3 arguments, got 4"},
+		{2, 2, []string{"a"}, "expected 2 arguments, got 1"},
+		{1, -1, []string{"a", "b", "c", "d", "e"}, ""},
+	}
+	for _, test := range tests {
+		flags := initalizeFlagSet()
+		flags.AddFlag("verbose", "v", "Verbose output")
+		if err := flags.SetArgRange(test.min, test.max); err != nil {
+			t.Fatal(err)
+		}
+
+		err := flags.Parse(append([]string{"util"}, test.args...)...)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.expect {
+			t.Errorf("%v: Expected %q, got %q", test.args, test.expect, got)
+		}
+	}
+
+	if err := initalizeFlagSet().SetArgRange(3, 1); err == nil {
+		t.Error("Expected error for inverted range")
+	}
+}
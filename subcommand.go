@@ -0,0 +1,231 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// AddSubcommand adds a subcommand with its own FlagSet, as in
+// "mytool build --release". ParseWithSubcommands dispatches to it.
+func (fs *FlagSet) AddSubcommand(name string, sub *FlagSet) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	if sub == nil {
+		return fmt.Errorf("%q: subcommand has no FlagSet", name)
+	}
+	if _, ok := fs.subcommands[name]; ok {
+		return fmt.Errorf("%q: subcommand already exists", name)
+	}
+
+	if fs.subcommands == nil {
+		fs.subcommands = make(map[string]*FlagSet)
+	}
+	fs.subcommands[name] = sub
+	return nil
+}
+
+// ParseWithSubcommands parses the flags of the FlagSet from args, or
+// from os.Args if no args are given, up to the first non-flag argument.
+// That argument names the subcommand whose FlagSet parses the rest.
+func (fs *FlagSet) ParseWithSubcommands(args ...string) error {
+	if len(args) == 0 {
+		args = os.Args
+	}
+	if err := fs.Parse(args...); err != nil {
+		return err
+	}
+
+	rest := fs.GetArgs()
+	if len(rest) == 0 {
+		return fmt.Errorf("missing subcommand, must be one of [%s]",
+			strings.Join(fs.subcommandNames(), "|"))
+	}
+
+	name := rest[0]
+	sub, ok := fs.subcommands[name]
+	if !ok {
+		return fs.unknownSubcommand(name)
+	}
+
+	fs.activeSub = name
+	if err := sub.Parse(append([]string{name}, rest[1:]...)...); err != nil {
+		return err
+	}
+	if fs.helpCommand && name == "help" {
+		return fs.help(sub.GetArgs())
+	}
+	return fs.checkRequiredFor(name)
+}
+
+// SetRequiredForSubcommand marks a flag of the FlagSet as mandatory
+// when one of the subcommands subs is chosen, as with a --region flag
+// needed by "deploy" but not by "status". ParseWithSubcommands returns
+// an error naming the flag and subcommand if it was not given; as with
+// SetRequired, a non-zero default satisfies the requirement.
+func (fs *FlagSet) SetRequiredForSubcommand(key string, subs ...string) error {
+	f, ok := fs.flag[key]
+	if !ok {
+		return fmt.Errorf("%q: flag does not exist", key)
+	}
+	if len(subs) == 0 {
+		return fmt.Errorf("%q: no subcommands given", key)
+	}
+	for _, name := range subs {
+		if _, ok := fs.subcommands[name]; !ok {
+			return fmt.Errorf("%q: subcommand does not exist", name)
+		}
+	}
+
+	f.requiredFor = append(f.requiredFor, subs...)
+	return nil
+}
+
+// checkRequiredFor returns an error listing the flags required for the
+// subcommand name that were not given
+func (fs *FlagSet) checkRequiredFor(name string) error {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	var missing []string
+	for _, f := range sortFlags(fs.flag) {
+		if f.isSet || f.envSet || f.hasDefault() {
+			continue
+		}
+		for _, sub := range f.requiredFor {
+			if sub == name {
+				missing = append(missing, fmt.Sprintf("%q", f.key))
+				break
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing flags required by subcommand %q: %s",
+			name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// EnableHelpCommand adds a built-in "help" subcommand, so that
+// "mytool help deploy" writes the usage of the deploy subcommand to the
+// output and "mytool help" the usage of the FlagSet itself, as with
+// git and go. ParseWithSubcommands then returns ErrHelp, as Parse does
+// for --help. Nested subcommands are named in turn, as in
+// "mytool help remote add".
+func (fs *FlagSet) EnableHelpCommand() error {
+	help := NewFlagSet("help")
+	help.FlagSetDescription("Show help for a command")
+	help.AddSemantics("[command]")
+	if err := fs.AddSubcommand("help", help); err != nil {
+		return err
+	}
+
+	fs.helpCommand = true
+	return nil
+}
+
+// help writes the usage of the subcommand named by path, or of the
+// FlagSet if path is empty, for the built-in help subcommand
+func (fs *FlagSet) help(path []string) error {
+	target := fs
+	for _, name := range path {
+		sub, ok := target.subcommands[name]
+		if !ok {
+			return target.unknownSubcommand(name)
+		}
+		target = sub
+	}
+
+	fmt.Fprintln(fs.Output(), target.usage(fs.useColor()))
+	return ErrHelp
+}
+
+// unknownSubcommand returns the error for a subcommand name that is
+// not defined, suggesting the closest one
+func (fs *FlagSet) unknownSubcommand(name string) error {
+	names := fs.subcommandNames()
+	if len(names) == 0 {
+		return fmt.Errorf("unknown subcommand %q", name)
+	}
+	if s := suggest(name, names); s != "" {
+		return fmt.Errorf("unknown subcommand %q, did you mean %q?", name, s)
+	}
+	return fmt.Errorf("unknown subcommand %q, must be one of [%s]",
+		name, strings.Join(names, "|"))
+}
+
+// ActiveSubcommand returns the name and FlagSet of the subcommand
+// chosen by ParseWithSubcommands, or "" and nil if none was
+func (fs *FlagSet) ActiveSubcommand() (string, *FlagSet) {
+	if fs.activeSub == "" {
+		return "", nil
+	}
+	return fs.activeSub, fs.subcommands[fs.activeSub]
+}
+
+// subcommandNames returns the names of the subcommands in sorted order
+func (fs *FlagSet) subcommandNames() []string {
+	names := make([]string, 0, len(fs.subcommands))
+	for name := range fs.subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commands builds the "Commands:" section of the usage
+func (fs *FlagSet) commands() string {
+	s := "\nCommands:"
+	for _, name := range fs.subcommandNames() {
+		s += fmt.Sprintf("\n  %s", name)
+		if d := fs.subcommands[name].description; d != "" {
+			s += fmt.Sprintf("\n     %s", d)
+		}
+	}
+	return s
+}
+
+// suggest returns the candidate closest to name, or "" if none is
+// close enough to be a likely typo
+func suggest(name string, candidates []string) string {
+	best, bestDist := "", len(name)/2+1
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
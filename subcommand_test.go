@@ -0,0 +1,160 @@
+package flagplus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func initializeSubcommands() (*FlagSet, *FlagSet, *FlagSet) {
+	flags := initalizeFlagSet()
+	flags.AddFlag("verbose", "v", "Verbose output")
+
+	build := NewFlagSet("build")
+	build.FlagSetDescription("Compile the project")
+	build.AddFlag("release", "r", "Optimized build")
+
+	deploy := NewFlagSet("deploy")
+	deploy.AddStringFlag("target", "t", "Deploy `host`", "localhost")
+
+	flags.AddSubcommand("build", build)
+	flags.AddSubcommand("deploy", deploy)
+	return flags, build, deploy
+}
+
+func TestFlagSet_ParseWithSubcommands(t *testing.T) {
+	flags, _, deploy := initializeSubcommands()
+	err := flags.ParseWithSubcommands("util", "-v", "deploy", "--target", "prod", "app")
+	if err != nil {
+		t.Fatalf("Could not parse subcommand: %v", err)
+	}
+
+	if verbose, _ := flags.Get("verbose"); !verbose {
+		t.Error("Expected parent flag to be set")
+	}
+	name, sub := flags.ActiveSubcommand()
+	if name != "deploy" || sub != deploy {
+		t.Errorf("Expected %q, got %q", "deploy", name)
+	}
+	if target, _ := sub.GetString("target"); target != "prod" {
+		t.Errorf("Expected %q, got %q", "prod", target)
+	}
+	if args := sub.GetArgs(); len(args) != 1 || args[0] != "app" {
+		t.Errorf("Expected %q, got %q", []string{"app"}, args)
+	}
+}
+
+func TestFlagSet_ParseWithSubcommands_Unknown(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"util", "biuld"}, `unknown subcommand "biuld", did you mean "build"?`},
+		{[]string{"util", "depl"}, `unknown subcommand "depl", did you mean "deploy"?`},
+		{[]string{"util", "test"}, `unknown subcommand "test", must be one of [build|deploy]`},
+		{[]string{"util", "-v"}, `missing subcommand, must be one of [build|deploy]`},
+	}
+	for _, test := range tests {
+		flags, _, _ := initializeSubcommands()
+		err := flags.ParseWithSubcommands(test.args...)
+		if err == nil || err.Error() != test.expect {
+			t.Errorf("Expected %q, got %v", test.expect, err)
+		}
+		if name, sub := flags.ActiveSubcommand(); name != "" || sub != nil {
+			t.Errorf("Expected no active subcommand, got %q", name)
+		}
+	}
+}
+
+func TestFlagSet_AddSubcommand(t *testing.T) {
+	flags, build, _ := initializeSubcommands()
+	if err := flags.AddSubcommand("build", build); err == nil {
+		t.Error("Expected error for duplicate subcommand")
+	}
+
+	usage := flags.Usage()
+	if !strings.Contains(usage, "<command>") {
+		t.Errorf("Expected command in synopsis, got %q", usage)
+	}
+	expect := "\nCommands:\n  build\n     Compile the project\n  deploy"
+	if !strings.Contains(usage, expect) {
+		t.Errorf("Expected %q in usage, got %q", expect, usage)
+	}
+}
+
+func TestFlagSet_EnableHelpCommand(t *testing.T) {
+	tests := []struct {
+		args   []string
+		expect func(flags, deploy *FlagSet) string
+	}{
+		{[]string{"util", "help", "deploy"}, func(flags, deploy *FlagSet) string { return deploy.Usage() }},
+		{[]string{"util", "-v", "help"}, func(flags, deploy *FlagSet) string { return flags.Usage() }},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		flags, _, deploy := initializeSubcommands()
+		flags.SetOutput(&out)
+		if err := flags.EnableHelpCommand(); err != nil {
+			t.Fatalf("Could not enable help command: %v", err)
+		}
+
+		if err := flags.ParseWithSubcommands(test.args...); err != ErrHelp {
+			t.Errorf("Expected ErrHelp, got %v", err)
+		}
+		if expect := test.expect(flags, deploy) + "\n"; out.String() != expect {
+			t.Errorf("Expected %q, got %q", expect, out.String())
+		}
+	}
+
+	flags, _, _ := initializeSubcommands()
+	flags.EnableHelpCommand()
+	expect := "\n  help\n     Show help for a command"
+	if usage := flags.Usage(); !strings.Contains(usage, expect) {
+		t.Errorf("Expected %q in usage, got %q", expect, usage)
+	}
+	err := flags.ParseWithSubcommands("util", "help", "deplyo")
+	if err == nil || !strings.HasPrefix(err.Error(), `unknown subcommand "deplyo"`) {
+		t.Errorf("Expected unknown subcommand error, got %v", err)
+	}
+	if err := flags.EnableHelpCommand(); err == nil {
+		t.Error("Expected error enabling the help command twice")
+	}
+}
+
+func TestFlagSet_SetRequiredForSubcommand(t *testing.T) {
+	setup := func() *FlagSet {
+		flags, _, _ := initializeSubcommands()
+		flags.AddSubcommand("status", NewFlagSet("status"))
+		flags.AddStringFlag("region", "", "Cloud `region`", "")
+		if err := flags.SetRequiredForSubcommand("region", "deploy"); err != nil {
+			t.Fatalf("Could not require flag: %v", err)
+		}
+		return flags
+	}
+
+	tests := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"util", "deploy"}, `missing flags required by subcommand "deploy": "region"`},
+		{[]string{"util", "--region", "eu", "deploy"}, ""},
+		{[]string{"util", "status"}, ""},
+	}
+	for _, test := range tests {
+		err := setup().ParseWithSubcommands(test.args...)
+		if test.expect == "" && err != nil {
+			t.Errorf("Could not parse %q: %v", test.args, err)
+		}
+		if test.expect != "" && (err == nil || err.Error() != test.expect) {
+			t.Errorf("Expected %q, got %v", test.expect, err)
+		}
+	}
+
+	flags := setup()
+	if err := flags.SetRequiredForSubcommand("zone", "deploy"); err == nil {
+		t.Error("Expected error for unknown flag")
+	}
+	if err := flags.SetRequiredForSubcommand("region", "destroy"); err == nil {
+		t.Error("Expected error for unknown subcommand")
+	}
+}
@@ -0,0 +1,749 @@
+// Copyright 2020 Scott Underwood.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package flagplus
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// typedMapValue is a flag.Value holding comma separated key=value
+// pairs, each value coerced to the type declared by the schema
+type typedMapValue struct {
+	m      *map[string]interface{}
+	schema map[string]FlagType
+}
+
+func (v *typedMapValue) String() string {
+	if v.m == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*v.m))
+	for k, val := range *v.m {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, val))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set merges the pairs in s into the map
+func (v *typedMapValue) Set(s string) error {
+	m := make(map[string]interface{}, len(*v.m))
+	for k, val := range *v.m {
+		m[k] = val
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return fmt.Errorf("%q: expected key=value", pair)
+		}
+		key, raw := pair[:i], pair[i+1:]
+
+		flagType, ok := v.schema[key]
+		if !ok {
+			return fmt.Errorf("%q: unknown key", key)
+		}
+		val, err := parseTyped(flagType, raw)
+		if err != nil {
+			return fmt.Errorf("%q: %v", key, err)
+		}
+		m[key] = val
+	}
+
+	*v.m = m
+	return nil
+}
+
+// parseTyped converts s to the Go type backing flagType
+func parseTyped(flagType FlagType, s string) (interface{}, error) {
+	switch flagType {
+	case BASE, BOOL:
+		return strconv.ParseBool(s)
+	case INT:
+		return strconv.ParseInt(s, 0, 64)
+	case UINT:
+		return strconv.ParseUint(s, 0, 64)
+	case FLOAT:
+		return strconv.ParseFloat(s, 64)
+	case STRING:
+		return s, nil
+	case DURATION:
+		return time.ParseDuration(s)
+	}
+	return nil, fmt.Errorf("unsupported type %q", typeName(flagType))
+}
+
+// semVerPattern matches major.minor.patch with optional pre-release
+// and build metadata
+var semVerPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// semVerValue is a flag.Value holding a validated semantic version
+type semVerValue struct {
+	p *string
+}
+
+func (v *semVerValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v *semVerValue) Set(s string) error {
+	if _, _, _, err := parseSemVer(s); err != nil {
+		return err
+	}
+	*v.p = s
+	return nil
+}
+
+// parseSemVer returns the numeric components of a semantic version
+func parseSemVer(s string) (major, minor, patch int, err error) {
+	m := semVerPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, 0, fmt.Errorf("%q: invalid semantic version", s)
+	}
+
+	major, _ = strconv.Atoi(m[1])
+	minor, _ = strconv.Atoi(m[2])
+	patch, _ = strconv.Atoi(m[3])
+	return major, minor, patch, nil
+}
+
+// byteUnits maps lower case size suffixes to their multipliers
+var byteUnits = map[string]int64{
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// bytesValue is a flag.Value holding a byte count. If total is
+// positive, a percentage of total is also accepted.
+type bytesValue struct {
+	p     *int64
+	total int64
+}
+
+func (v *bytesValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v.p, 10)
+}
+
+func (v *bytesValue) Set(s string) error {
+	if v.total > 0 && strings.HasSuffix(s, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || math.IsNaN(pct) || pct < 0 || pct > 100 {
+			return fmt.Errorf("%q: invalid percentage", s)
+		}
+		*v.p = int64(pct / 100 * float64(v.total))
+		return nil
+	}
+
+	n, err := parseBytes(s)
+	if err != nil {
+		return err
+	}
+	*v.p = n
+	return nil
+}
+
+// parseBytes converts a size such as 1024, 10MB or 1GiB to a byte
+// count. KB, MB, GB and TB are decimal; KiB, MiB, GiB and TiB binary.
+func parseBytes(s string) (int64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	num, suffix := s[:i], strings.TrimSpace(s[i:])
+
+	mult := int64(1)
+	if suffix != "" {
+		m, ok := byteUnits[strings.ToLower(suffix)]
+		if !ok {
+			return 0, fmt.Errorf("%q: unknown size suffix %q", s, suffix)
+		}
+		mult = m
+	}
+
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		if n > math.MaxInt64/mult {
+			return 0, fmt.Errorf("%q: size out of range", s)
+		}
+		return n * mult, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%q: invalid size", s)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, itself out of range
+	if f*float64(mult) >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("%q: size out of range", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// percentValue is a flag.Value holding a percentage from 0 to 100,
+// with or without a trailing %
+type percentValue struct {
+	p *float64
+}
+
+func (v *percentValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v.p, 'g', -1, 64)
+}
+
+func (v *percentValue) Set(s string) error {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || math.IsNaN(pct) {
+		return fmt.Errorf("%q: invalid percentage", s)
+	}
+	if pct < 0 || pct > 100 {
+		return fmt.Errorf("%q: percentage out of range [0,100]", s)
+	}
+	*v.p = pct
+	return nil
+}
+
+// resetter is implemented by flag.Values holding state besides their
+// value that Reset must clear
+type resetter interface {
+	reset()
+}
+
+// stringSliceValue is a flag.Value appending each use to a string
+// slice. The first use replaces the default.
+type stringSliceValue struct {
+	p   *[]string
+	set bool
+}
+
+func (v *stringSliceValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+func (v *stringSliceValue) Set(s string) error {
+	if !v.set {
+		*v.p = nil
+		v.set = true
+	}
+	*v.p = append(*v.p, s)
+	return nil
+}
+
+func (v *stringSliceValue) reset() {
+	v.set = false
+}
+
+// sortSpecValue is a flag.Value holding comma separated sort fields,
+// each one of fields and optionally prefixed with "-" for descending
+type sortSpecValue struct {
+	p      *[]SortField
+	fields []string
+}
+
+func (v *sortSpecValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	parts := make([]string, len(*v.p))
+	for i, sf := range *v.p {
+		parts[i] = sf.Field
+		if sf.Descending {
+			parts[i] = "-" + sf.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v *sortSpecValue) Set(s string) error {
+	var spec []SortField
+	for _, part := range strings.Split(s, ",") {
+		sf := SortField{Field: strings.TrimSpace(part)}
+		if strings.HasPrefix(sf.Field, "-") {
+			sf.Field = sf.Field[1:]
+			sf.Descending = true
+		}
+
+		known := false
+		for _, f := range v.fields {
+			if f == sf.Field {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown sort field %q, must be one of [%s]",
+				sf.Field, strings.Join(v.fields, "|"))
+		}
+		spec = append(spec, sf)
+	}
+
+	*v.p = spec
+	return nil
+}
+
+// intSliceValue is a flag.Value appending comma separated integers
+// to a slice. The first use replaces the default.
+type intSliceValue struct {
+	p   *[]int64
+	set bool
+}
+
+func (v *intSliceValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return formatInts(*v.p)
+}
+
+func (v *intSliceValue) Set(s string) error {
+	var list []int64
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(part), 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", part)
+		}
+		list = append(list, n)
+	}
+
+	if !v.set {
+		*v.p = nil
+		v.set = true
+	}
+	*v.p = append(*v.p, list...)
+	return nil
+}
+
+func (v *intSliceValue) reset() {
+	v.set = false
+}
+
+// maxRangeList caps the number of integers a range list may expand
+// to, so that a typo such as "1-1000000000" is an error rather than an
+// enormous allocation
+const maxRangeList = 1 << 16
+
+// rangeListValue is a flag.Value expanding comma separated integers
+// and inclusive ranges such as "1-3,5" into a sorted list without
+// duplicates. Each use adds to the list.
+type rangeListValue struct {
+	p *[]int64
+}
+
+func (v *rangeListValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return formatInts(*v.p)
+}
+
+func (v *rangeListValue) Set(s string) error {
+	seen := make(map[int64]bool, len(*v.p))
+	for _, n := range *v.p {
+		seen[n] = true
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, err := parseRange(part)
+		if err != nil {
+			return err
+		}
+		if hi-lo >= maxRangeList || len(seen)+int(hi-lo) >= maxRangeList {
+			return fmt.Errorf("range %q expands to more than %d values", part, maxRangeList)
+		}
+		// Stop at hi rather than past it, which would overflow at MaxInt64
+		for n := lo; ; n++ {
+			seen[n] = true
+			if n == hi {
+				break
+			}
+		}
+	}
+
+	list := make([]int64, 0, len(seen))
+	for n := range seen {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	*v.p = list
+	return nil
+}
+
+// parseRange parses a single integer or an inclusive range "a-b"
+func parseRange(s string) (int64, int64, error) {
+	loStr, hiStr, isRange := strings.Cut(s, "-")
+	lo, err := strconv.ParseInt(loStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	if !isRange {
+		return lo, lo, nil
+	}
+
+	hi, err := strconv.ParseInt(hiStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("inverted range %q", s)
+	}
+	return lo, hi, nil
+}
+
+// countValue is a flag.Value counting how often a flag is given. Like
+// a boolean flag it takes no value; an explicit false resets the count.
+type countValue struct {
+	p *int
+}
+
+func (v *countValue) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*v.p)
+}
+
+func (v *countValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if b {
+		*v.p++
+	} else {
+		*v.p = 0
+	}
+	return nil
+}
+
+func (v *countValue) IsBoolFlag() bool {
+	return true
+}
+
+// ipValue is a flag.Value holding an IPv4 or IPv6 address
+type ipValue struct {
+	p *net.IP
+}
+
+func (v *ipValue) String() string {
+	if v.p == nil || *v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+
+func (v *ipValue) Set(s string) error {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address %q", s)
+	}
+	*v.p = ip
+	return nil
+}
+
+// timeValue is a flag.Value holding a timestamp in a layout
+type timeValue struct {
+	p      *time.Time
+	layout string
+}
+
+func (v *timeValue) String() string {
+	if v.p == nil || v.p.IsZero() {
+		return ""
+	}
+	return v.p.Format(v.layout)
+}
+
+func (v *timeValue) Set(s string) error {
+	t, err := time.Parse(v.layout, s)
+	if err != nil {
+		return err
+	}
+	*v.p = t
+	return nil
+}
+
+// urlValue is a flag.Value holding an absolute URL
+type urlValue struct {
+	p       **url.URL
+	schemes []string
+}
+
+func (v *urlValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return formatURL(*v.p)
+}
+
+func (v *urlValue) Set(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if err := checkURL(u, v.schemes); err != nil {
+		return err
+	}
+	*v.p = u
+	return nil
+}
+
+// checkURL reports whether u is absolute, has a host where one is
+// expected and uses one of schemes, if any are given. A nil URL passes.
+func checkURL(u *url.URL, schemes []string) error {
+	if u == nil {
+		return nil
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("URL %q has no scheme", u)
+	}
+	if u.Host == "" && u.Opaque == "" && u.Scheme != "file" {
+		return fmt.Errorf("URL %q has no host", u)
+	}
+	if len(schemes) == 0 {
+		return nil
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("URL scheme %q not allowed, must be one of [%s]", u.Scheme, strings.Join(schemes, "|"))
+}
+
+// formatURL renders a URL, which may be nil
+func formatURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// regexpValue is a flag.Value holding a compiled regular expression
+type regexpValue struct {
+	p **regexp.Regexp
+}
+
+func (v *regexpValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return formatRegexp(*v.p)
+}
+
+func (v *regexpValue) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*v.p = re
+	return nil
+}
+
+// formatRegexp renders the pattern of a regular expression, which may
+// be nil
+func formatRegexp(re *regexp.Regexp) string {
+	if re == nil {
+		return ""
+	}
+	return re.String()
+}
+
+// linesFileValue is a flag.Value appending the lines of each named
+// file to a string slice
+type linesFileValue struct {
+	p *[]string
+}
+
+func (v *linesFileValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+func (v *linesFileValue) Set(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		*v.p = append(*v.p, line)
+	}
+	return nil
+}
+
+// globValue is a flag.Value holding a filepath.Match pattern
+type globValue struct {
+	p *string
+}
+
+func (v *globValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v *globValue) Set(s string) error {
+	if _, err := filepath.Match(s, ""); err != nil {
+		return fmt.Errorf("%q: invalid glob pattern", s)
+	}
+	*v.p = s
+	return nil
+}
+
+// filePathValue is a flag.Value holding a file path, which may have
+// to name an existing file
+type filePathValue struct {
+	p         *string
+	mustExist bool
+}
+
+func (v *filePathValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v *filePathValue) Set(s string) error {
+	if v.mustExist {
+		info, err := os.Stat(s)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%q: is a directory", s)
+		}
+	}
+	*v.p = s
+	return nil
+}
+
+// signedIntValue is a flag.Value holding an integer with an optional
+// explicit sign
+type signedIntValue struct {
+	p *int64
+}
+
+func (v *signedIntValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v.p, 10)
+}
+
+func (v *signedIntValue) Set(s string) error {
+	n, err := strconv.ParseInt(strings.TrimPrefix(s, "+"), 10, 64)
+	if err != nil || strings.HasPrefix(s, "+-") {
+		return fmt.Errorf("%q: invalid signed integer", s)
+	}
+	*v.p = n
+	return nil
+}
+
+// quantityValue is a flag.Value converting a number with a unit suffix
+// to a canonical unit
+type quantityValue struct {
+	p         *float64
+	units     map[string]float64
+	canonical string
+}
+
+func (v *quantityValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v.p, 'g', -1, 64)
+}
+
+func (v *quantityValue) Set(s string) error {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		*v.p = n
+		return nil
+	}
+
+	// Match the longest unit suffix
+	unit := ""
+	for u := range v.units {
+		if strings.HasSuffix(s, u) && len(u) > len(unit) {
+			unit = u
+		}
+	}
+	if unit == "" {
+		return fmt.Errorf("%q: unknown unit", s)
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSuffix(s, unit), 64)
+	if err != nil {
+		return fmt.Errorf("%q: invalid quantity", s)
+	}
+	*v.p = n * v.units[unit]
+	return nil
+}
+
+// intFileValue is a flag.Value holding an integer given literally or
+// read from a file named by "@path"
+type intFileValue struct {
+	p *int64
+}
+
+func (v *intFileValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v.p, 10)
+}
+
+func (v *intFileValue) Set(s string) error {
+	if strings.HasPrefix(s, "@") {
+		data, err := os.ReadFile(s[1:])
+		if err != nil {
+			return err
+		}
+		s = strings.TrimSpace(string(data))
+	}
+
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return fmt.Errorf("%q: invalid integer", s)
+	}
+	*v.p = n
+	return nil
+}